@@ -0,0 +1,89 @@
+package simplecache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+)
+
+// Backend is implemented by every supported cache format.
+type Backend interface {
+	// Keys returns the keys of all the entries currently stored in the cache.
+	Keys() ([]string, error)
+	// Open returns the entry stored for key.
+	Open(key string) (Record, error)
+	// Close releases the resources held by the backend.
+	Close() error
+}
+
+// Record is an entry of a Backend.
+type Record interface {
+	// Key returns the key of the entry, usually an URL.
+	Key() string
+	// Response returns the HTTP response stored in the entry.
+	// The caller must close the response body.
+	Response() (*http.Response, error)
+	// Stream returns the data stream index of the entry, as stored by the backend.
+	// Stream 0 holds the HTTP headers and stream 1 holds the body.
+	Stream(index int) (io.ReadCloser, error)
+	// Close releases the files held open by the entry.
+	Close() error
+}
+
+// Open opens the cache directory at path.
+//
+// Open reads the file named "path/index" to detect whether the cache is
+// stored with the simple backend or with the blockfile backend.
+// A path containing an "entries" directory is opened as a Firefox cache.
+func Open(path string) (Backend, error) {
+	fsys := os.DirFS(path)
+	if isCache2(fsys) {
+		return OpenCache2(path)
+	}
+
+	magic, err := readIndexMagic(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %v", path, err)
+	}
+	if magic == blockIndexMagic {
+		return OpenBlockfile(path)
+	}
+	return OpenCache(path)
+}
+
+// OpenFS opens the cache stored at the root of fsys, like a directory of a zip archive
+// returned by fs.Sub. The backend is detected as described in Open, and the cache is
+// read-only, see OpenCacheFS.
+func OpenFS(fsys fs.FS) (Backend, error) {
+	if isCache2(fsys) {
+		return OpenCache2FS(fsys)
+	}
+
+	magic, err := readIndexMagic(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("open fs: %v", err)
+	}
+	if magic == blockIndexMagic {
+		return OpenBlockfileFS(fsys)
+	}
+	return OpenCacheFS(fsys)
+}
+
+// readIndexMagic reads the first four bytes of the index file.
+func readIndexMagic(fsys fs.FS) (uint32, error) {
+	file, err := openFile(fsys, "index")
+	if err != nil {
+		return 0, fmt.Errorf("open index: %v", err)
+	}
+	defer close(file)
+
+	var magic uint32
+	err = binary.Read(file, binary.LittleEndian, &magic)
+	if err != nil {
+		return 0, fmt.Errorf("read index magic: %v", err)
+	}
+	return magic, nil
+}
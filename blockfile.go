@@ -0,0 +1,491 @@
+package simplecache
+
+// Blockfile backend:
+// http://www.chromium.org/developers/design-documents/network-stack/disk-cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+)
+
+const (
+	blockIndexMagic    uint32 = 0xc103cac3
+	blockFileMagic     uint32 = 0xc104cac3
+	blockVersion2      uint32 = 0x20000
+	blockVersion3      uint32 = 0x30000
+	blockIndexTableLen int32  = 0x10000
+
+	blockIndexHeaderSize int64 = 368
+	blockIndexFieldsSize int64 = 48
+	blockFileHeaderSize  int64 = 8192
+	blockFileFieldsSize  int64 = 80
+	entryStoreSize       int64 = 256
+	entryStoreKeySize    int64 = 160
+
+	entryNormal int32 = 0
+)
+
+// blockIndexHeader is the beginning of the header of a blockfile index file.
+type blockIndexHeader struct {
+	Magic      uint32
+	Version    uint32
+	NumEntries int32
+	NumBytes   int32
+	LastFile   int32
+	ThisID     int32
+	Stats      cacheAddr
+	TableLen   int32
+	Crash      int32
+	Experiment int32
+	CreateTime uint64
+}
+
+// blockFileHeader is the beginning of the header of a block file (data_#).
+type blockFileHeader struct {
+	Magic      uint32
+	Version    uint32
+	ThisFile   int16
+	NextFile   int16
+	EntrySize  int32
+	NumEntries int32
+	MaxEntries int32
+	Empty      [4]int32
+	Hints      [4]int32
+	Updating   int32
+	User       [5]int32
+}
+
+// entryStore is an entry in a block file.
+type entryStore struct {
+	Hash         uint32
+	Next         cacheAddr
+	RankingsNode cacheAddr
+	ReuseCount   int32
+	RefetchCount int32
+	State        int32
+	CreationTime uint64
+	KeyLen       int32
+	LongKey      cacheAddr
+	DataSize     [4]int32
+	DataAddr     [4]cacheAddr
+	Flags        uint32
+	_            [4]int32
+	SelfHash     uint32
+	Key          [entryStoreKeySize]byte
+}
+
+// cacheAddr is the address of some data in a block file or in an external file.
+type cacheAddr uint32
+
+func (a cacheAddr) Initialized() bool {
+	return a&0x80000000 != 0
+}
+
+func (a cacheAddr) FileType() uint32 {
+	return uint32(a&0x70000000) >> 28
+}
+
+func (a cacheAddr) External() bool {
+	return a.FileType() == 0
+}
+
+// Name returns the name of the file where the data is stored.
+func (a cacheAddr) Name() string {
+	if a.External() {
+		return fmt.Sprintf("f_%06x", uint32(a&0x0fffffff))
+	}
+	return fmt.Sprintf("data_%d", uint32(a&0x00ff0000)>>16)
+}
+
+// BlockSize returns the size of a block, or 0 for external files.
+func (a cacheAddr) BlockSize() int64 {
+	switch a.FileType() {
+	case 1:
+		return 36
+	case 2:
+		return 256
+	case 3:
+		return 1024
+	case 4:
+		return 4096
+	}
+	return 0
+}
+
+// Offset returns the offset of the data in its file.
+func (a cacheAddr) Offset() int64 {
+	if a.External() {
+		return 0
+	}
+	start := int64(a & 0x0000ffff)
+	return blockFileHeaderSize + start*a.BlockSize()
+}
+
+// Len returns the size of the blocks pointed by the address.
+func (a cacheAddr) Len() int64 {
+	blocks := int64(a&0x03000000)>>24 + 1
+	return blocks * a.BlockSize()
+}
+
+// Blockfile is a Chromium cache directory stored with the blockfile backend.
+//
+// A blockfile cache contains different files:
+//   - index: the hash table of the entries
+//   - data_0: the rankings
+//   - data_1, data_2, data_3: blocks of 256, 1k and 4k bytes
+//   - f_######: the external files, for data larger than 16k
+type Blockfile struct {
+	// Limits bounds the sizes read from the cache files, the default limits for its zero
+	// fields. It should be set before the Blockfile is used.
+	Limits Limits
+
+	path string
+	fsys fs.FS
+}
+
+// OpenBlockfile opens the blockfile cache directory at path.
+// An error is returned if the format of the index file is unexpected.
+func OpenBlockfile(path string) (*Blockfile, error) {
+	cache := Blockfile{path: path, fsys: os.DirFS(path)}
+	if _, err := cache.readIndex(); err != nil {
+		return nil, fmt.Errorf("open %s: %v", path, err)
+	}
+	return &cache, nil
+}
+
+// OpenBlockfileFS opens the blockfile cache stored at the root of fsys, see OpenCacheFS.
+func OpenBlockfileFS(fsys fs.FS) (*Blockfile, error) {
+	cache := Blockfile{path: ".", fsys: fsys}
+	if _, err := cache.readIndex(); err != nil {
+		return nil, fmt.Errorf("open fs: %v", err)
+	}
+	return &cache, nil
+}
+
+// URLs returns all the URLs currently stored in the cache.
+//
+// URLs reads the file named "path/index" and every entry
+// stored in the block files named "path/data_#".
+func (b *Blockfile) URLs() ([]string, error) {
+	return b.URLsContext(context.Background())
+}
+
+// URLsContext returns all the URLs currently stored in the cache, see URLs.
+// No more entry is read once ctx is done, and ctx.Err() is returned.
+func (b *Blockfile) URLsContext(ctx context.Context) ([]string, error) {
+	var urls []string
+	table, err := b.readIndex()
+	if err != nil {
+		return urls, fmt.Errorf("get urls from %s: %v", b.path, err)
+	}
+
+	// seen breaks the loops of corrupt chains
+	seen := make(map[cacheAddr]bool)
+	for _, addr := range table {
+		for addr.Initialized() && !seen[addr] {
+			if err = ctx.Err(); err != nil {
+				return nil, fmt.Errorf("get urls from %s: %v", b.path, err)
+			}
+			seen[addr] = true
+			store, err := b.readEntryStore(addr)
+			if err != nil {
+				log.Printf("Unable to get entry %08x from %s: %v\n", uint32(addr), b.path, err)
+				break
+			}
+			addr = store.Next
+
+			if store.State != entryNormal {
+				continue
+			}
+			key, err := b.readKey(store)
+			if err != nil {
+				log.Printf("Unable to get entry %08x from %s: %v\n", store.Hash, b.path, err)
+				continue
+			}
+			urls = append(urls, key)
+		}
+	}
+	return urls, nil
+}
+
+// Get returns the Entry for the specified URL.
+// An error is returned if the format of the entry does not match the one expected.
+func (b *Blockfile) Get(url string) (*Entry, error) {
+	return b.GetContext(context.Background(), url)
+}
+
+// GetContext returns the Entry for the specified URL, see Get.
+// No more entry is read once ctx is done, and ctx.Err() is returned.
+func (b *Blockfile) GetContext(ctx context.Context, url string) (*Entry, error) {
+	table, err := b.readIndex()
+	if err != nil {
+		return nil, fmt.Errorf("getting %s: %v", url, err)
+	}
+
+	hash := superFastHash([]byte(url))
+	addr := table[hash&uint32(len(table)-1)]
+
+	seen := make(map[cacheAddr]bool)
+	for addr.Initialized() && !seen[addr] {
+		if err = ctx.Err(); err != nil {
+			return nil, fmt.Errorf("getting %s: %v", url, err)
+		}
+		seen[addr] = true
+		store, err := b.readEntryStore(addr)
+		if err != nil {
+			return nil, fmt.Errorf("getting %s: %v", url, err)
+		}
+		addr = store.Next
+
+		if store.Hash != hash || store.State != entryNormal {
+			continue
+		}
+		key, err := b.readKey(store)
+		if err != nil {
+			return nil, fmt.Errorf("getting %s: %v", url, err)
+		}
+		if key == url {
+			entry, err := b.newEntry(key, store)
+			if err != nil {
+				return nil, fmt.Errorf("getting %s: %v", url, err)
+			}
+			return entry, nil
+		}
+	}
+
+	return nil, fmt.Errorf("getting %s: entry not found", url)
+}
+
+// Keys returns all the URLs currently stored in the cache, see URLs.
+func (b *Blockfile) Keys() ([]string, error) {
+	return b.URLs()
+}
+
+// Open returns the Entry for the specified URL, see Get.
+func (b *Blockfile) Open(url string) (Record, error) {
+	entry, err := b.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// OpenContext returns the Entry for the specified URL, see GetContext.
+func (b *Blockfile) OpenContext(ctx context.Context, url string) (Record, error) {
+	entry, err := b.GetContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Close implements Backend, it has nothing to release.
+func (b *Blockfile) Close() error {
+	return nil
+}
+
+// limits returns b.Limits with the default limits for its zero fields.
+func (b *Blockfile) limits() Limits {
+	return b.Limits.orDefault()
+}
+
+func (b *Blockfile) newEntry(key string, store entryStore) (*Entry, error) {
+	limits := b.limits()
+	if err := checkSize("stream0 size", int64(store.DataSize[0]), limits.MaxHeaderSize); err != nil {
+		return nil, err
+	}
+	if err := checkSize("stream1 size", int64(store.DataSize[1]), limits.MaxStreamSize); err != nil {
+		return nil, err
+	}
+	if err := b.checkAddrSize("stream0 size", store.DataAddr[0], int64(store.DataSize[0])); err != nil {
+		return nil, err
+	}
+	if err := b.checkAddrSize("stream1 size", store.DataAddr[1], int64(store.DataSize[1])); err != nil {
+		return nil, err
+	}
+
+	entry := Entry{
+		URL:       key,
+		fsys:      b.fsys,
+		keyLen:    int64(store.KeyLen),
+		dataSize0: int64(store.DataSize[0]),
+		dataSize1: int64(store.DataSize[1]),
+		limits:    limits,
+	}
+	if addr := store.DataAddr[0]; addr.Initialized() {
+		entry.name0 = addr.Name()
+		entry.offset0 = addr.Offset()
+	}
+	if addr := store.DataAddr[1]; addr.Initialized() {
+		entry.name1 = addr.Name()
+		entry.offset1 = addr.Offset()
+	}
+	return &entry, nil
+}
+
+// checkAddrSize verifies that size bytes are stored at addr: within the blocks
+// of addr for a block file, and within the size of the file.
+func (b *Blockfile) checkAddrSize(name string, addr cacheAddr, size int64) error {
+	if !addr.Initialized() {
+		return checkSize(name, size, 0)
+	}
+	info, err := fs.Stat(b.fsys, addr.Name())
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	max := info.Size() - addr.Offset()
+	if !addr.External() && addr.Len() < max {
+		max = addr.Len()
+	}
+	return checkSize(name, size, max)
+}
+
+// readIndex reads the hash table of the "index" file.
+func (b *Blockfile) readIndex() ([]cacheAddr, error) {
+	file, err := openFile(b.fsys, "index")
+	if err != nil {
+		return nil, fmt.Errorf("open index: %v", err)
+	}
+	defer close(file)
+
+	var header blockIndexHeader
+	err = binary.Read(file, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, fmt.Errorf("read index header: %v", err)
+	}
+
+	if header.Magic != blockIndexMagic {
+		return nil, fmt.Errorf("index magic: %x, want: %x",
+			header.Magic, blockIndexMagic)
+	}
+	if header.Version < blockVersion2 || header.Version > blockVersion3 {
+		return nil, fmt.Errorf("index version: %x, want: %x to %x",
+			header.Version, blockVersion2, blockVersion3)
+	}
+
+	tableLen := header.TableLen
+	if tableLen == 0 {
+		tableLen = blockIndexTableLen
+	}
+	if tableLen < 0 || tableLen&(tableLen-1) != 0 {
+		return nil, fmt.Errorf("index table length: %d", tableLen)
+	}
+
+	table := make([]cacheAddr, tableLen)
+	section := io.NewSectionReader(file, blockIndexHeaderSize, int64(tableLen)*4)
+	err = binary.Read(section, binary.LittleEndian, table)
+	if err != nil {
+		return nil, fmt.Errorf("read index table: %v", err)
+	}
+	return table, nil
+}
+
+// readEntryStore reads the entry stored at addr.
+func (b *Blockfile) readEntryStore(addr cacheAddr) (entryStore, error) {
+	var store entryStore
+	if addr.FileType() != 2 {
+		return store, fmt.Errorf("entry address %08x: not a 256 bytes block", uint32(addr))
+	}
+
+	buf := make([]byte, entryStoreSize)
+	if err := b.readAddr(addr, buf); err != nil {
+		return store, fmt.Errorf("read entry: %v", err)
+	}
+
+	err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &store)
+	if err != nil {
+		return store, fmt.Errorf("read entry: %v", err)
+	}
+	return store, nil
+}
+
+// readKey reads the key of an entry, either stored inline with the entry
+// or, if too long, in a separate block or external file.
+func (b *Blockfile) readKey(store entryStore) (string, error) {
+	if err := checkSize("key length", int64(store.KeyLen), b.limits().MaxKeySize); err != nil {
+		return "", err
+	}
+	key := make([]byte, store.KeyLen)
+
+	if store.LongKey.Initialized() {
+		if err := b.readAddr(store.LongKey, key); err != nil {
+			return "", fmt.Errorf("read long key: %v", err)
+		}
+	} else {
+		if int64(store.KeyLen) > entryStoreKeySize {
+			return "", fmt.Errorf("key length: %d, want: <= %d",
+				store.KeyLen, entryStoreKeySize)
+		}
+		copy(key, store.Key[:])
+	}
+
+	sfh := superFastHash(key)
+	if store.Hash != sfh {
+		return "", fmt.Errorf("key hash: %x, want: %x", store.Hash, sfh)
+	}
+	return string(key), nil
+}
+
+// readAddr reads len(p) bytes from the data stored at addr.
+func (b *Blockfile) readAddr(addr cacheAddr, p []byte) error {
+	file, err := openFile(b.fsys, addr.Name())
+	if err != nil {
+		return err
+	}
+	defer close(file)
+
+	if !addr.External() {
+		if int64(len(p)) > addr.Len() {
+			return fmt.Errorf("address %08x: %d bytes, want: <= %d",
+				uint32(addr), len(p), addr.Len())
+		}
+		if err = checkBlockFile(file); err != nil {
+			return err
+		}
+	}
+
+	_, err = file.ReadAt(p, addr.Offset())
+	return err
+}
+
+// checkBlockFile verifies the header of a block file.
+func checkBlockFile(file file) error {
+	var header blockFileHeader
+	err := binary.Read(io.NewSectionReader(file, 0, blockFileFieldsSize), binary.LittleEndian, &header)
+	if err != nil {
+		return fmt.Errorf("read block-file header: %v", err)
+	}
+
+	if header.Magic != blockFileMagic {
+		return fmt.Errorf("block-file magic: %x, want: %x",
+			header.Magic, blockFileMagic)
+	}
+	if header.Version < blockVersion2 || header.Version > blockVersion3 {
+		return fmt.Errorf("block-file version: %x, want: %x to %x",
+			header.Version, blockVersion2, blockVersion3)
+	}
+	return nil
+}
+
+func init() {
+	var index blockIndexHeader
+	if n := binary.Size(index); int64(n) != blockIndexFieldsSize {
+		log.Fatalf("BlockIndexHeader size error: %d, want: %d", n, blockIndexFieldsSize)
+	}
+
+	var header blockFileHeader
+	if n := binary.Size(header); int64(n) != blockFileFieldsSize {
+		log.Fatalf("BlockFileHeader size error: %d, want: %d", n, blockFileFieldsSize)
+	}
+
+	var store entryStore
+	if n := binary.Size(store); int64(n) != entryStoreSize {
+		log.Fatalf("EntryStore size error: %d, want: %d", n, entryStoreSize)
+	}
+}
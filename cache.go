@@ -0,0 +1,103 @@
+package simplecache
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// Cache is a Chromium cache directory stored with the simple backend.
+type Cache struct {
+	// Workers is the number of entry files read concurrently by URLs, Trim and Verify,
+	// runtime.NumCPU() if zero. It should be set before the Cache is used.
+	Workers int
+
+	// Mmap, if true, maps the entry files in memory (with mmap on Linux) instead of reading them:
+	// the entries are parsed from the mapped files, and their streams are read without copy,
+	// see Entry.BodyBytes. The files stay mapped until their entries are closed, they must
+	// not be modified in place meanwhile. It should be set before the Cache is used.
+	Mmap bool
+
+	// Sidecar is the name of a file, stored outside of the cache directory, where Stats keeps
+	// the keys and the metadata of the entries, so that the unchanged entry files are not
+	// read again by Stats and URLs. The sidecar file is not used if Sidecar is empty.
+	Sidecar string
+
+	// Limits bounds the sizes read from the cache files, the default limits for its zero
+	// fields. It should be set before the Cache is used.
+	Limits Limits
+
+	path     string
+	fsys     fs.FS      // the files are read from fsys, and written to path
+	readOnly bool       // the cache is opened with OpenCacheFS or OpenSnapshot
+	snapshot bool       // the cache is a copy made by OpenSnapshot, removed by Close
+	mu       sync.Mutex // guards the-real-index updates
+}
+
+// OpenCache opens the simple cache directory at path.
+// An error is returned if the format of the fake index file is unexpected.
+func OpenCache(path string) (*Cache, error) {
+	fsys := os.DirFS(path)
+	if err := checkFakeIndex(fsys); err != nil {
+		return nil, fmt.Errorf("open %s: %v", path, err)
+	}
+	return &Cache{path: path, fsys: fsys}, nil
+}
+
+// OpenCacheFS opens the simple cache stored at the root of fsys,
+// like a directory of a zip archive returned by fs.Sub.
+//
+// The cache is read-only: the methods writing to the cache return an error.
+// The files which do not implement io.ReaderAt and io.Seeker are read in memory.
+func OpenCacheFS(fsys fs.FS) (*Cache, error) {
+	if err := checkFakeIndex(fsys); err != nil {
+		return nil, fmt.Errorf("open fs: %v", err)
+	}
+	return &Cache{path: ".", fsys: fsys, readOnly: true}, nil
+}
+
+// limits returns c.Limits with the default limits for its zero fields.
+func (c *Cache) limits() Limits {
+	return c.Limits.orDefault()
+}
+
+// checkWritable returns an error if the cache is opened with OpenCacheFS or OpenSnapshot.
+func (c *Cache) checkWritable() error {
+	if c.readOnly {
+		return fmt.Errorf("read-only cache")
+	}
+	return nil
+}
+
+// Keys returns all the URLs currently stored in the cache, see URLs.
+func (c *Cache) Keys() ([]string, error) {
+	return c.URLs()
+}
+
+// Open returns the Entry for the specified URL, see Get.
+func (c *Cache) Open(url string) (Record, error) {
+	entry, err := c.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// OpenContext returns the Entry for the specified URL, see GetContext.
+func (c *Cache) OpenContext(ctx context.Context, url string) (Record, error) {
+	entry, err := c.GetContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Close implements Backend, it removes the copy of a cache opened with OpenSnapshot.
+func (c *Cache) Close() error {
+	if c.snapshot {
+		return os.RemoveAll(c.path)
+	}
+	return nil
+}
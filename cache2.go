@@ -0,0 +1,466 @@
+package simplecache
+
+// Firefox cache2:
+// https://searchfox.org/mozilla-central/source/netwerk/cache2/CacheFileMetadata.h
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	cache2ChunkSize      int64  = 256 * 1024
+	cache2MetadataV1     uint32 = 1
+	cache2MetadataV3     uint32 = 3
+	cache2HeaderSizeV1   int64  = 28
+	cache2HeaderSize     int64  = 32
+	cache2ResponseHeadEl        = "response-head"
+)
+
+// cache2Header is the metadata header of a cache2 entry file, big-endian.
+// Flags exists since version 2.
+type cache2Header struct {
+	Version        uint32
+	FetchCount     uint32
+	LastFetched    uint32
+	LastModified   uint32
+	Frecency       uint32
+	ExpirationTime uint32
+	KeySize        uint32
+	Flags          uint32
+}
+
+// Cache2 is a Firefox cache directory (cache2).
+//
+// On linux, valid cache paths are:
+//
+//	~/.cache/mozilla/firefox/<profile>/cache2
+//
+// Each entry is stored in a file named "path/entries/SHA1(key)", which consists of:
+//   - the data stream (the body)
+//   - the metadata:
+//   - the hash of the metadata
+//   - the hashes of every 256k chunk of data
+//   - the metadata header
+//   - the key
+//   - the elements, like "response-head"
+//   - the offset of the metadata
+type Cache2 struct {
+	// Limits bounds the sizes read from the cache files, the default limits for its zero
+	// fields. It should be set before the Cache2 is used.
+	Limits Limits
+
+	path string
+	fsys fs.FS
+}
+
+// OpenCache2 opens the Firefox cache directory at path.
+// An error is returned if path has no "entries" directory.
+func OpenCache2(path string) (*Cache2, error) {
+	fsys := os.DirFS(path)
+	if !isCache2(fsys) {
+		return nil, fmt.Errorf("open %s: no entries directory", path)
+	}
+	return &Cache2{path: path, fsys: fsys}, nil
+}
+
+// OpenCache2FS opens the Firefox cache stored at the root of fsys, see OpenCacheFS.
+func OpenCache2FS(fsys fs.FS) (*Cache2, error) {
+	if !isCache2(fsys) {
+		return nil, fmt.Errorf("open fs: no entries directory")
+	}
+	return &Cache2{path: ".", fsys: fsys}, nil
+}
+
+func isCache2(fsys fs.FS) bool {
+	info, err := fs.Stat(fsys, "entries")
+	return err == nil && info.IsDir()
+}
+
+// URLs returns all the URLs currently stored in the cache.
+// URLs reads every entry files named "path/entries/SHA1(key)".
+func (c *Cache2) URLs() ([]string, error) {
+	return c.URLsContext(context.Background())
+}
+
+// URLsContext returns all the URLs currently stored in the cache, see URLs.
+// No more entry file is read once ctx is done, and ctx.Err() is returned.
+func (c *Cache2) URLsContext(ctx context.Context) ([]string, error) {
+	var urls []string
+	names, err := c.entryNames()
+	if err != nil {
+		return urls, fmt.Errorf("get urls from %s: %v", c.path, err)
+	}
+	urls = make([]string, 0, len(names))
+
+	for _, name := range names {
+		if err = ctx.Err(); err != nil {
+			return nil, fmt.Errorf("get urls from %s: %v", c.path, err)
+		}
+		entry, err := c.readEntry(name)
+		if err != nil {
+			log.Printf("Unable to get %s from %s: %v\n", name, c.path, err)
+			continue
+		}
+		urls = append(urls, entry.URL)
+	}
+	return urls, nil
+}
+
+// Get returns the Cache2Entry for the specified URL.
+//
+// The entry is first looked up by the keys Firefox uses for a plain URL
+// and then, if not found, among every entry of the cache.
+// An error is returned if the format of the entry does not match the one expected.
+func (c *Cache2) Get(url string) (*Cache2Entry, error) {
+	return c.GetContext(context.Background(), url)
+}
+
+// GetContext returns the Cache2Entry for the specified URL, see Get.
+// No more entry file is read once ctx is done, and ctx.Err() is returned.
+func (c *Cache2) GetContext(ctx context.Context, url string) (*Cache2Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("getting %s: %v", url, err)
+	}
+	for _, key := range []string{":" + url, "a,:" + url, url} {
+		name := fmt.Sprintf("%X", sha1.Sum([]byte(key)))
+		entry, err := c.readEntry(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("getting %s: %v", url, err)
+		}
+		if entry.URL == url {
+			return c.verify(ctx, entry)
+		}
+	}
+
+	names, err := c.entryNames()
+	if err != nil {
+		return nil, fmt.Errorf("getting %s: %v", url, err)
+	}
+	for _, name := range names {
+		if err = ctx.Err(); err != nil {
+			return nil, fmt.Errorf("getting %s: %v", url, err)
+		}
+		entry, err := c.readEntry(name)
+		if err == nil && entry.URL == url {
+			return c.verify(ctx, entry)
+		}
+	}
+	return nil, fmt.Errorf("getting %s: entry not found", url)
+}
+
+// Keys returns all the URLs currently stored in the cache, see URLs.
+func (c *Cache2) Keys() ([]string, error) {
+	return c.URLs()
+}
+
+// Open returns the Cache2Entry for the specified URL, see Get.
+func (c *Cache2) Open(url string) (Record, error) {
+	entry, err := c.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// OpenContext returns the Cache2Entry for the specified URL, see GetContext.
+func (c *Cache2) OpenContext(ctx context.Context, url string) (Record, error) {
+	entry, err := c.GetContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Close implements Backend, it has nothing to release.
+func (c *Cache2) Close() error {
+	return nil
+}
+
+// limits returns c.Limits with the default limits for its zero fields.
+func (c *Cache2) limits() Limits {
+	return c.Limits.orDefault()
+}
+
+func (c *Cache2) entryNames() ([]string, error) {
+	entries, err := fs.ReadDir(c.fsys, "entries")
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// readEntry reads the metadata of the entry file named "path/entries/name".
+func (c *Cache2) readEntry(name string) (*Cache2Entry, error) {
+	file, err := openFile(c.fsys, "entries/"+name)
+	if err != nil {
+		return nil, err
+	}
+	defer close(file)
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	entry := Cache2Entry{
+		fsys:     c.fsys,
+		name:     "entries/" + name,
+		fileSize: stat.Size(),
+	}
+	if err = entry.readMetadata(file, c.limits()); err != nil {
+		return nil, fmt.Errorf("read %s: %v", name, err)
+	}
+	return &entry, nil
+}
+
+// verify checks the hashes of every chunk of the entry data, until ctx is done.
+func (c *Cache2) verify(ctx context.Context, e *Cache2Entry) (*Cache2Entry, error) {
+	file, err := openFile(e.fsys, e.name)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s: %v", e.URL, err)
+	}
+	defer close(file)
+
+	chunk := make([]byte, cache2ChunkSize)
+	for i, expected := range e.chunkHashes {
+		if err = ctx.Err(); err != nil {
+			return nil, fmt.Errorf("getting %s: %v", e.URL, err)
+		}
+		offset := int64(i) * cache2ChunkSize
+		n := cache2ChunkSize
+		if offset+n > e.dataSize {
+			n = e.dataSize - offset
+		}
+
+		_, err = file.ReadAt(chunk[:n], offset)
+		if err != nil {
+			return nil, fmt.Errorf("getting %s: read chunk %d: %v", e.URL, i, err)
+		}
+
+		actual := uint16(lookup2(chunk[:n], 0))
+		if expected != actual {
+			return nil, fmt.Errorf("getting %s: chunk %d hash: %x, want: %x",
+				e.URL, i, expected, actual)
+		}
+	}
+	return e, nil
+}
+
+// Cache2Entry represents a HTTP response as stored in a Firefox cache.
+type Cache2Entry struct {
+	URL         string
+	key         string
+	fsys        fs.FS
+	name        string
+	fileSize    int64
+	dataSize    int64
+	chunkHashes []uint16
+	elements    map[string]string
+}
+
+func (e *Cache2Entry) readMetadata(file io.ReaderAt, limits Limits) error {
+	var offset [4]byte
+	if e.fileSize < int64(len(offset)) {
+		return fmt.Errorf("metadata offset: file too small")
+	}
+	_, err := file.ReadAt(offset[:], e.fileSize-int64(len(offset)))
+	if err != nil {
+		return fmt.Errorf("read metadata offset: %v", err)
+	}
+
+	// dataSize
+	e.dataSize = int64(binary.BigEndian.Uint32(offset[:]))
+	metaSize := e.fileSize - int64(len(offset)) - e.dataSize
+	if metaSize < 4 {
+		return fmt.Errorf("metadata offset: %d, want: < %d",
+			e.dataSize, e.fileSize-4-4)
+	}
+	if err = checkSize("metadata size", metaSize, limits.MaxHeaderSize); err != nil {
+		return err
+	}
+
+	meta := make([]byte, metaSize)
+	_, err = file.ReadAt(meta, e.dataSize)
+	if err != nil {
+		return fmt.Errorf("read metadata: %v", err)
+	}
+
+	expectedHash := binary.BigEndian.Uint32(meta)
+	actualHash := lookup2(meta[4:], 0)
+	if expectedHash != actualHash {
+		return fmt.Errorf("metadata hash: %x, want: %x", expectedHash, actualHash)
+	}
+	meta = meta[4:]
+
+	// chunkHashes
+	chunks := int((e.dataSize + cache2ChunkSize - 1) / cache2ChunkSize)
+	if len(meta) < chunks*2 {
+		return fmt.Errorf("metadata chunk hashes: %d, want: %d", len(meta)/2, chunks)
+	}
+	e.chunkHashes = make([]uint16, chunks)
+	for i := range e.chunkHashes {
+		e.chunkHashes[i] = binary.BigEndian.Uint16(meta[i*2:])
+	}
+	meta = meta[chunks*2:]
+
+	var header cache2Header
+	err = binary.Read(bytes.NewReader(meta), binary.BigEndian, &header)
+	if err != nil {
+		return fmt.Errorf("read metadata header: %v", err)
+	}
+	if header.Version < cache2MetadataV1 || header.Version > cache2MetadataV3 {
+		return fmt.Errorf("metadata version: %d, want: %d to %d",
+			header.Version, cache2MetadataV1, cache2MetadataV3)
+	}
+	if header.Version == cache2MetadataV1 {
+		meta = meta[cache2HeaderSizeV1:]
+	} else {
+		meta = meta[cache2HeaderSize:]
+	}
+
+	// key
+	if err = checkSize("metadata key size", int64(header.KeySize), limits.MaxKeySize); err != nil {
+		return err
+	}
+	if int64(header.KeySize) >= int64(len(meta)) || meta[header.KeySize] != 0 {
+		return fmt.Errorf("metadata key size: %d", header.KeySize)
+	}
+	e.key = string(meta[:header.KeySize])
+	e.URL = cache2URL(e.key)
+	meta = meta[header.KeySize+1:]
+
+	// elements
+	e.elements = make(map[string]string)
+	fields := bytes.Split(meta, []byte{0})
+	for i := 0; i+1 < len(fields); i += 2 {
+		e.elements[string(fields[i])] = string(fields[i+1])
+	}
+
+	return nil
+}
+
+// cache2URL returns the URL of a cache2 key,
+// like "a,:https://example.com/" or "O^partitionKey=...,:https://example.com/".
+func cache2URL(key string) string {
+	if strings.HasPrefix(key, ":") {
+		return key[1:]
+	}
+	if i := strings.Index(key, ",:"); i >= 0 {
+		return key[i+2:]
+	}
+	return key
+}
+
+// Key returns the URL of the entry.
+func (e *Cache2Entry) Key() string {
+	return e.URL
+}
+
+// Header returns the HTTP header, read from the "response-head" element.
+func (e *Cache2Entry) Header() (http.Header, error) {
+	resp, err := e.response()
+	if err != nil {
+		return nil, err
+	}
+	return resp.Header, nil
+}
+
+// Response returns the HTTP response, its body is read as described in Body.
+func (e *Cache2Entry) Response() (*http.Response, error) {
+	resp, err := e.response()
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body, err = e.Body()
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (e *Cache2Entry) response() (*http.Response, error) {
+	head, ok := e.elements[cache2ResponseHeadEl]
+	if !ok {
+		return nil, fmt.Errorf("read header: no %s", cache2ResponseHeadEl)
+	}
+
+	lines := bytes.Split([]byte(head), []byte("\r\n"))
+	resp, err := newResponse(lines[0], headerLines(lines[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("read header: %v", err)
+	}
+	return resp, nil
+}
+
+// Body returns the HTTP body.
+func (e *Cache2Entry) Body() (io.ReadCloser, error) {
+	file, err := openFile(e.fsys, e.name)
+	if err != nil {
+		return nil, fmt.Errorf("open body: %v", err)
+	}
+
+	return &streamReader{
+		SectionReader: io.NewSectionReader(file, 0, e.dataSize),
+		file:          file,
+	}, nil
+}
+
+// BodyContext returns the HTTP body, see Body.
+// Reading the body returns ctx.Err() once ctx is done.
+func (e *Cache2Entry) BodyContext(ctx context.Context) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	body, err := e.Body()
+	if err != nil {
+		return nil, err
+	}
+	return contextReader{ReadCloser: body, ctx: ctx}, nil
+}
+
+// Stream returns the stream index of the entry.
+// Stream 0 is the "response-head" element and stream 1 is the body.
+func (e *Cache2Entry) Stream(index int) (io.ReadCloser, error) {
+	switch index {
+	case 0:
+		head := e.elements[cache2ResponseHeadEl]
+		return ioutil.NopCloser(strings.NewReader(head)), nil
+	case 1:
+		return e.Body()
+	}
+	return nil, fmt.Errorf("stream %d: not supported", index)
+}
+
+// Close implements Record, the entry holds no file open.
+func (e *Cache2Entry) Close() error {
+	return nil
+}
+
+func init() {
+	var header cache2Header
+	if n := binary.Size(header); int64(n) != cache2HeaderSize {
+		log.Fatalf("Cache2Header size error: %d, want: %d", n, cache2HeaderSize)
+	}
+}
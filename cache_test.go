@@ -1,10 +1,18 @@
 package simplecache_test
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/schorlet/simplecache"
 )
@@ -23,6 +31,403 @@ func TestURLs(t *testing.T) {
 	}
 }
 
+func TestURLsWorkers(t *testing.T) {
+	cache, err := simplecache.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Workers = 1
+	want, err := cache.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workers := range []int{0, 4, 100} {
+		cache.Workers = workers
+		urls, err := cache.URLs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(urls, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("workers %d: urls: %q, want: %q", workers, urls, want)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	cache, err := simplecache.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	urls, err := cache.URLsContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := cache.GetContext(ctx, urls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	body, err := entry.BodyContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	cancel()
+	if _, err = body.Read(make([]byte, 1)); err != context.Canceled {
+		t.Fatalf("read body: %v, want: %v", err, context.Canceled)
+	}
+	if _, err = entry.BodyContext(ctx); err != context.Canceled {
+		t.Fatalf("body: %v, want: %v", err, context.Canceled)
+	}
+	if _, err = cache.URLsContext(ctx); err == nil {
+		t.Fatal("urls: err is nil")
+	}
+	if _, err = cache.GetContext(ctx, urls[0]); err == nil {
+		t.Fatal("get: err is nil")
+	}
+
+	// the other backends
+	blockfile, err := simplecache.OpenBlockfile("testdata/blockfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = blockfile.URLsContext(ctx); err == nil {
+		t.Fatal("blockfile urls: err is nil")
+	}
+	if _, err = blockfile.GetContext(ctx, "https://example.com/"); err == nil {
+		t.Fatal("blockfile get: err is nil")
+	}
+	cache2, err := simplecache.OpenCache2("testdata/cache2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cache2.URLsContext(ctx); err == nil {
+		t.Fatal("cache2 urls: err is nil")
+	}
+	if _, err = cache2.GetContext(ctx, "https://example.com/"); err == nil {
+		t.Fatal("cache2 get: err is nil")
+	}
+}
+
+// countContext is done once Err is called n times.
+type countContext struct {
+	context.Context
+	n int
+}
+
+func (c *countContext) Err() error {
+	if c.n == 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestContextSparse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Cache")
+	cache, err := simplecache.CreateCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "https://example.com/video.mp4"
+	if err = cache.WriteSparse(url, 0, make([]byte, 1<<20)); err != nil {
+		t.Fatal(err)
+	}
+
+	// ctx is done while the entry is read: once opened, then after its header
+	_, err = cache.GetContext(&countContext{Context: context.Background(), n: 1}, url)
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("get: %v, want: %v", err, context.Canceled)
+	}
+
+	entry, err := cache.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+
+	// ctx is done while the ranges are scanned: by BodyContext, by the sparse reader,
+	// then by the first range
+	ctx := &countContext{Context: context.Background(), n: 2}
+	if _, err = entry.BodyContext(ctx); err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("body: %v, want: %v", err, context.Canceled)
+	}
+
+	// ctx is done while the range is read: once the range and the end of the file
+	// are scanned, by Read, then by the first chunk
+	ctx = &countContext{Context: context.Background(), n: 5}
+	body, err := entry.BodyContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if _, err = body.Read(make([]byte, 1)); err != context.Canceled {
+		t.Fatalf("read body: %v, want: %v", err, context.Canceled)
+	}
+}
+
+func TestBlockfileURLs(t *testing.T) {
+	cache, err := simplecache.OpenBlockfile("testdata/blockfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls, err := cache.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 19 {
+		t.Fatalf("urls: %d, want: %d", len(urls), 19)
+	}
+
+	for i := range urls {
+		testEntry(t, urls[i], "testdata/blockfile")
+	}
+}
+
+func TestBlockfileChainLoop(t *testing.T) {
+	path := copyDir(t, filepath.Join("testdata", "blockfile"))
+
+	index, err := ioutil.ReadFile(filepath.Join(path, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data1, err := ioutil.ReadFile(filepath.Join(path, "data_1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every bucket of the index table points to an entry whose next entry is itself
+	const tableOffset = 368
+	tableLen := int(binary.LittleEndian.Uint32(index[28:]))
+	if tableLen == 0 {
+		tableLen = 0x10000
+	}
+	var addr uint32
+	for i := 0; i < tableLen && addr == 0; i++ {
+		addr = binary.LittleEndian.Uint32(index[tableOffset+4*i:])
+	}
+	for i := 0; i < tableLen; i++ {
+		binary.LittleEndian.PutUint32(index[tableOffset+4*i:], addr)
+	}
+	offset := 8192 + 256*int(addr&0xffff)
+	binary.LittleEndian.PutUint32(data1[offset+4:], addr)
+
+	if err = ioutil.WriteFile(filepath.Join(path, "index"), index, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(path, "data_1"), data1, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := simplecache.OpenBlockfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		urls, err := cache.URLs()
+		if err == nil && len(urls) != 1 {
+			err = fmt.Errorf("urls: %d, want: %d", len(urls), 1)
+		}
+		if _, gerr := cache.Get("https://example.com/missing"); gerr == nil && err == nil {
+			err = errors.New("get: err is nil")
+		}
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("chain loop")
+	}
+}
+
+func TestBlockfileDataSize(t *testing.T) {
+	path := copyDir(t, filepath.Join("testdata", "blockfile"))
+	cache, err := simplecache.OpenBlockfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls, err := cache.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := ioutil.ReadFile(filepath.Join(path, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data1, err := ioutil.ReadFile(filepath.Join(path, "data_1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the stream 1 of the first entry of the index table is larger than its file
+	const tableOffset = 368
+	var addr uint32
+	for i := 0; addr == 0; i++ {
+		addr = binary.LittleEndian.Uint32(index[tableOffset+4*i:])
+	}
+	offset := 8192 + 256*int(addr&0xffff)
+	binary.LittleEndian.PutUint32(data1[offset+44:], 1<<20)
+	if err = ioutil.WriteFile(filepath.Join(path, "data_1"), data1, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var failed int
+	for _, url := range urls {
+		entry, err := cache.Get(url)
+		if err != nil {
+			if !strings.Contains(err.Error(), "stream1 size") {
+				t.Fatalf("%s: err: %v, want: stream1 size", url, err)
+			}
+			failed++
+			continue
+		}
+		entry.Close()
+	}
+	if failed != 1 {
+		t.Fatalf("failed: %d, want: %d", failed, 1)
+	}
+}
+
+func TestCache2URLs(t *testing.T) {
+	cache, err := simplecache.OpenCache2("testdata/cache2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls, err := cache.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 19 {
+		t.Fatalf("urls: %d, want: %d", len(urls), 19)
+	}
+
+	for _, url := range urls {
+		entry, err := cache.Get(url)
+		if err != nil {
+			t.Fatalf("get entry: %v", err)
+		}
+		if entry.URL != url {
+			t.Fatalf("url: %s, want: %s", entry.URL, url)
+		}
+
+		header, err := entry.Header()
+		if err != nil {
+			t.Fatalf("header: %v", err)
+		}
+		if header.Get("Content-Type") == "" {
+			t.Fatalf("header: no Content-Type")
+		}
+	}
+
+	_, err = cache.Get("http://foo.com")
+	if err == nil {
+		t.Fatalf("err is nil")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	cache, err := simplecache.Open("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.(*simplecache.Cache); !ok {
+		t.Fatalf("open testdata: %T, want: *simplecache.Cache", cache)
+	}
+
+	cache, err = simplecache.Open("testdata/blockfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.(*simplecache.Blockfile); !ok {
+		t.Fatalf("open testdata/blockfile: %T, want: *simplecache.Blockfile", cache)
+	}
+
+	cache, err = simplecache.Open("testdata/cache2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.(*simplecache.Cache2); !ok {
+		t.Fatalf("open testdata/cache2: %T, want: *simplecache.Cache2", cache)
+	}
+}
+
+func TestBackends(t *testing.T) {
+	memory := simplecache.NewMemory()
+	err := memory.Put("http://foo.com/", &http.Response{
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Length": {"3"}},
+		Body:       ioutil.NopCloser(strings.NewReader("foo")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"testdata", "testdata/blockfile", "testdata/cache2"} {
+		backend, err := simplecache.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testBackend(t, backend)
+	}
+	testBackend(t, memory)
+}
+
+func testBackend(t *testing.T, backend simplecache.Backend) {
+	defer backend.Close()
+
+	keys, err := backend.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) == 0 {
+		t.Fatal("keys is empty")
+	}
+
+	for _, key := range keys {
+		record, err := backend.Open(key)
+		if err != nil {
+			t.Fatalf("open record: %v", err)
+		}
+		if record.Key() != key {
+			t.Fatalf("key: %s, want: %s", record.Key(), key)
+		}
+
+		resp, err := record.Response()
+		if err != nil {
+			t.Fatalf("response: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status code: %d, want: %d", resp.StatusCode, http.StatusOK)
+		}
+		n, err := io.Copy(ioutil.Discard, resp.Body)
+		if err != nil {
+			t.Fatalf("discard body: %v", err)
+		}
+		if err = resp.Body.Close(); err != nil {
+			t.Fatalf("close body: %v", err)
+		}
+		if n != resp.ContentLength {
+			t.Fatalf("body length: %d, want: %d", n, resp.ContentLength)
+		}
+	}
+
+	if _, err = backend.Open("http://bar.com/"); err == nil {
+		t.Fatal("err is nil")
+	}
+}
+
 func testEntry(t *testing.T, url, path string) {
 	entry, err := simplecache.Get(url, path)
 	if err != nil {
@@ -66,4 +471,9 @@ func TestBadURL(t *testing.T) {
 	if err == nil {
 		t.Fatalf("err is nil")
 	}
+
+	_, err = simplecache.Get("http://foo.com", "testdata/blockfile")
+	if err == nil {
+		t.Fatalf("err is nil")
+	}
 }
@@ -0,0 +1,183 @@
+// Package carve recovers Chromium simple cache entries from raw data,
+// like disk images, unallocated space or memory dumps.
+//
+// The data is scanned for the magic number starting the entry files and the
+// sparse files. The key hash, the EOF records and the CRCs of every candidate
+// are verified, and the recovered entries are stored in a simple cache directory.
+package carve
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/schorlet/simplecache"
+)
+
+const (
+	// initialMagicNumber starts the entry files "hash_0" and the sparse files "hash_s".
+	initialMagicNumber uint64 = 0xfcfb6d1ba7725c30
+	// entryVersion is the version of the entry files,
+	// the version of the sparse files is the version of the index.
+	entryVersion uint32 = 5
+
+	// DefaultMaxEntrySize is the default maximum size of a carved file.
+	DefaultMaxEntrySize int64 = 16 << 20
+
+	scanChunkSize int64 = 1 << 20
+)
+
+// Options controls Carve.
+type Options struct {
+	// MaxEntrySize is the maximum size of a carved file, DefaultMaxEntrySize if zero.
+	// A larger entry file is carved as truncated.
+	MaxEntrySize int64
+}
+
+// Stats counts the files recovered by Carve.
+type Stats struct {
+	// Entries is the number of entries recovered from entry files.
+	Entries int
+	// Truncated is the number of entries recovered from truncated entry files only.
+	Truncated int
+	// Sparse is the number of sparse files recovered.
+	Sparse int
+	// Invalid is the number of candidates whose header or CRC is not valid.
+	Invalid int
+	// Headerless is the number of truncated candidates left out because their
+	// HTTP response info (stream 0) was not recovered.
+	Headerless int
+}
+
+// Carve scans the size bytes of r for entry files and sparse files, and stores them in dst.
+//
+// An entry found many times is stored from its first complete copy, or from its
+// last truncated copy if every copy is truncated. The truncated copies are stored
+// without CRC, and left out if their HTTP response info was not recovered, see
+// Cache.PutRecovered. The sparse files are stored once every entry file is stored,
+// so that their entries keep the recovered HTTP response info.
+func Carve(r io.ReaderAt, size int64, dst *simplecache.Cache, opts Options) (Stats, error) {
+	var stats Stats
+	if opts.MaxEntrySize <= 0 {
+		opts.MaxEntrySize = DefaultMaxEntrySize
+	}
+
+	offsets, err := scan(r, size)
+	if err != nil {
+		return stats, fmt.Errorf("carve: %v", err)
+	}
+
+	// truncated reports whether the stored copy of an entry is truncated
+	truncated := make(map[string]bool)
+	var sparse []int64
+	buf := make([]byte, opts.MaxEntrySize)
+
+	for _, offset := range offsets {
+		data, err := readWindow(r, size, offset, buf)
+		if err != nil {
+			return stats, fmt.Errorf("carve at %d: %v", offset, err)
+		}
+
+		if len(data) < 12 {
+			stats.Invalid++
+			continue
+		}
+		version := binary.LittleEndian.Uint32(data[8:])
+		if version != entryVersion {
+			sparse = append(sparse, offset)
+			continue
+		}
+
+		entry, err := simplecache.DecodeEntry(data)
+		if err != nil {
+			stats.Invalid++
+			continue
+		}
+		if t, ok := truncated[entry.URL]; ok && !t {
+			continue
+		}
+		stream0, err := entry.Stream(0)
+		if err != nil {
+			stats.Headerless++
+			continue
+		}
+		stream0.Close()
+		if err = dst.PutRecovered(entry); err != nil {
+			return stats, fmt.Errorf("carve at %d: %v", offset, err)
+		}
+		truncated[entry.URL] = entry.Truncated
+	}
+
+	for _, offset := range sparse {
+		data, err := readWindow(r, size, offset, buf)
+		if err != nil {
+			return stats, fmt.Errorf("carve at %d: %v", offset, err)
+		}
+
+		file, err := simplecache.DecodeSparse(data)
+		if err != nil || len(file.Ranges) == 0 {
+			stats.Invalid++
+			continue
+		}
+		for _, rng := range file.Ranges {
+			if err = dst.WriteSparse(file.URL, rng.Offset, rng.Data); err != nil {
+				return stats, fmt.Errorf("carve at %d: %v", offset, err)
+			}
+		}
+		stats.Sparse++
+	}
+
+	stats.Entries = len(truncated)
+	for _, t := range truncated {
+		if t {
+			stats.Truncated++
+		}
+	}
+	return stats, nil
+}
+
+// scan returns the offsets of the initial magic number in the size bytes of r.
+func scan(r io.ReaderAt, size int64) ([]int64, error) {
+	var magic [8]byte
+	binary.LittleEndian.PutUint64(magic[:], initialMagicNumber)
+
+	var offsets []int64
+	chunk := make([]byte, scanChunkSize+int64(len(magic))-1)
+
+	for start := int64(0); start < size; start += scanChunkSize {
+		n, err := r.ReadAt(chunk[:min64(int64(len(chunk)), size-start)], start)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		for i := 0; ; {
+			j := bytes.Index(chunk[i:n], magic[:])
+			if j < 0 {
+				break
+			}
+			if int64(i+j) < scanChunkSize {
+				offsets = append(offsets, start+int64(i+j))
+			}
+			i += j + 1
+		}
+	}
+	return offsets, nil
+}
+
+// readWindow reads at most len(buf) bytes of r at offset.
+func readWindow(r io.ReaderAt, size, offset int64, buf []byte) ([]byte, error) {
+	data := buf[:min64(int64(len(buf)), size-offset)]
+	n, err := r.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
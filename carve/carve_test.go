@@ -0,0 +1,133 @@
+package carve_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/schorlet/simplecache"
+	"github.com/schorlet/simplecache/carve"
+)
+
+func TestCarve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	names, err := filepath.Glob(filepath.Join("..", "testdata", "*_0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the image holds every entry file of testdata between random data,
+	// a truncated copy of the first one, only a copy of the next to last one
+	// without the EOF record of stream 0, and only half of the last one
+	random := rand.New(rand.NewSource(1))
+	var image bytes.Buffer
+	junk := func() {
+		b := make([]byte, 1000+random.Intn(10000))
+		random.Read(b)
+		image.Write(b)
+	}
+
+	for i, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		junk()
+		if i == 0 {
+			image.Write(data[:len(data)/2])
+			junk()
+		}
+		if i == len(names)-2 {
+			data = data[:len(data)-10]
+		}
+		if i == len(names)-1 {
+			data = data[:len(data)/2]
+		}
+		image.Write(data)
+	}
+
+	// and a sparse file
+	sparse, err := simplecache.CreateCache(filepath.Join(dir, "Sparse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "https://example.com/video.webm"
+	if err = sparse.WriteSparse(url, 0, []byte("sparse data")); err != nil {
+		t.Fatal(err)
+	}
+	sparseNames, err := filepath.Glob(filepath.Join(dir, "Sparse", "*_s"))
+	if err != nil || len(sparseNames) != 1 {
+		t.Fatalf("sparse files: %v, %v", sparseNames, err)
+	}
+	data, err := ioutil.ReadFile(sparseNames[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	junk()
+	image.Write(data)
+	junk()
+
+	path := filepath.Join(dir, "Cache")
+	dst, err := simplecache.CreateCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := carve.Carve(bytes.NewReader(image.Bytes()), int64(image.Len()), dst, carve.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := carve.Stats{Entries: len(names) - 1, Truncated: 1, Sparse: 1, Headerless: 2}
+	if stats != want {
+		t.Fatalf("stats: %+v, want: %+v", stats, want)
+	}
+
+	src, err := simplecache.OpenCache(filepath.Join("..", "testdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls, err := dst.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != len(names) {
+		t.Fatalf("urls: %d, want: %d", len(urls), len(names))
+	}
+
+	for _, u := range urls {
+		if u == url {
+			continue
+		}
+		if body := readBody(t, dst, u); !bytes.Equal(body, readBody(t, src, u)) {
+			t.Fatalf("%s: body differs", u)
+		}
+	}
+	if body := readBody(t, dst, url); string(body) != "sparse data" {
+		t.Fatalf("sparse body: %q, want: %q", body, "sparse data")
+	}
+}
+
+func readBody(t *testing.T, cache *simplecache.Cache, url string) []byte {
+	entry, err := cache.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := entry.Body()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -1,15 +1,22 @@
-// Package simplecache provides support for reading Chromium simple cache.
+// Package simplecache provides support for reading Chromium simple cache, index v6 to v9.
 // http://www.chromium.org/developers/design-documents/network-stack/disk-cache/very-simple-backend
+//
+// Caches stored with the older blockfile backend, and Firefox caches (cache2),
+// can be read too, see Open.
 package simplecache
 
 import (
 	"encoding/binary"
 	"log"
+	"time"
 )
 
 const (
-	indexMagicNumber uint64 = 0x656e74657220796f
-	indexVersion     uint32 = 6
+	indexMagicNumber   uint64 = 0x656e74657220796f
+	indexVersion       uint32 = 6
+	indexVersionLast   uint32 = 9
+	indexVersionPacked uint32 = 8 // entry sizes are counted in 256 bytes chunks
+	keySHA256Version   uint32 = 7 // entries store the SHA-256 of their key after stream 0
 
 	indexHeaderSize int64 = 36
 	indexEntrySize  int64 = 24
@@ -56,6 +63,67 @@ type indexEntry struct {
 	Size     uint64
 }
 
+// realIndex is the content of the the-real-index file.
+type realIndex struct {
+	Header   indexHeader
+	Reason   uint32 // last write reason, starting v7
+	Entries  []indexEntry
+	Modified int64
+}
+
+// entrySize returns the size of an entry in bytes.
+func (index *realIndex) entrySize(entry indexEntry) uint64 {
+	if index.Header.Version < indexVersionPacked {
+		return entry.Size
+	}
+	return (entry.Size >> 8) * 256
+}
+
+// packSize returns the value of indexEntry.Size for an entry of size bytes.
+// Starting v8, the low byte of indexEntry.Size holds the in-memory data of the entry.
+func (index *realIndex) packSize(size uint64, inMemory uint64) uint64 {
+	if index.Header.Version < indexVersionPacked {
+		return size
+	}
+	return ((size+255)/256)<<8 | inMemory&0xff
+}
+
+// put adds or replaces the entry in the index.
+func (index *realIndex) put(entry indexEntry) {
+	for i := range index.Entries {
+		if index.Entries[i].Hash == entry.Hash {
+			index.Entries[i] = entry
+			return
+		}
+	}
+	index.Entries = append(index.Entries, entry)
+}
+
+// remove removes the entry of hash from the index, it returns false if there is no such entry.
+func (index *realIndex) remove(hash uint64) bool {
+	for i := range index.Entries {
+		if index.Entries[i].Hash == hash {
+			index.Entries = append(index.Entries[:i], index.Entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// chromeTime returns the Chromium internal time value of t,
+// the number of microseconds since 1601-01-01 UTC.
+func chromeTime(t time.Time) int64 {
+	return t.UnixNano()/int64(time.Microsecond) + chromeEpochDelta
+}
+
+// fromChromeTime returns the time of a Chromium internal time value.
+func fromChromeTime(v int64) time.Time {
+	return time.Unix(0, (v-chromeEpochDelta)*int64(time.Microsecond))
+}
+
+// chromeEpochDelta is the number of microseconds between 1601-01-01 and 1970-01-01.
+const chromeEpochDelta int64 = 11644473600000000
+
 // entryHeader is the header of an entry file.
 type entryHeader struct {
 	Magic   uint64
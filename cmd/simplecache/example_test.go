@@ -84,6 +84,34 @@ func Example_header() {
 	// X-Cloud-Trace-Context: b75923ae8631de089fbc3f00e79cc992
 }
 
+func Example_snapshot() {
+	cmd := exec.Command("./simplecache", "-snapshot", "header", "https://golang.org/doc/gopher/pkg.png", "../../testdata")
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+
+	if err := cmd.Run(); err != nil {
+		log.Fatal(err)
+	}
+
+	lines := read(&output)
+	for i := range lines {
+		fmt.Println(lines[i])
+	}
+
+	// Output:
+	// Accept-Ranges: bytes
+	// Alt-Svc: quic=":443"; ma=2592000; v="36,35,34,33,32,31,30,29,28,27,26,25"
+	// Alternate-Protocol: 443:quic
+	// Content-Length: 5409
+	// Content-Type: image/png
+	// Date: Sun, 17 Jul 2016 18:30:09 GMT
+	// Last-Modified: Thu, 19 May 2016 18:04:32 GMT
+	// Server: Google Frontend
+	// Status: 200
+	// X-Cloud-Trace-Context: b75923ae8631de089fbc3f00e79cc992
+}
+
 func Example_body() {
 	cmd := exec.Command("./simplecache", "body", "https://golang.org/doc/gopher/pkg.png", "../../testdata")
 
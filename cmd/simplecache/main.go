@@ -1,52 +1,236 @@
-// Command simplecache helps reading chromium simple cache v6 or v7.
+// Command simplecache helps reading chromium simple cache v6 to v9,
+// chromium blockfile cache and firefox cache2.
 //
 //  Usage:
-//	simplecache command [url] path
+//	simplecache [-snapshot] command [url] path
 //
 //	The commands are:
 //		list        print cache urls
+//		stats       print the size, time and content type of cache urls
 //		header      print url header
 //		body        print url body
+//		rm          remove url from cache
+//		trim        remove entries by size, age or host
+//		eviction    print the entries chromium would evict
+//		merge       copy the entries of caches to a cache
+//		migrate     rewrite a cache to another index version
+//		compact     merge the ranges of url sparse data
+//		repair      salvage the corrupt entry of url
+//		recover     print url body from a truncated entry
+//		carve       recover the entries of a disk image to a cache
+//		verify      check the integrity of the cache, or fsck
 //
-//	path is the path to the chromium cache directory.
+//	-snapshot reads a copy of the simple cache path, see simplecache.OpenSnapshot.
+//
+//	path is the path to the chromium cache directory,
+//	stored with the simple or the blockfile backend,
+//	or to the firefox cache2 directory.
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"sort"
+	"time"
 
 	"github.com/schorlet/simplecache"
+	"github.com/schorlet/simplecache/carve"
 )
 
-const usage = `simplecache helps reading chromium simple cache v6 or v7.
+const usage = `simplecache helps reading chromium simple cache v6 to v9,
+chromium blockfile cache and firefox cache2.
 
 Usage:
-    simplecache command [url] path
+    simplecache [-snapshot] command [url] path
 
 The commands are:
     list        print cache urls
+    stats       print the size, time and content type of cache urls
     header      print url header
     body        print url body
+    rm          remove url from cache
+    trim        remove entries by size, age or host
+    eviction    print the entries chromium would evict
+    merge       copy the entries of caches to a cache
+    migrate     rewrite a cache to another index version
+    compact     merge the ranges of url sparse data
+    repair      salvage the corrupt entry of url
+    recover     print url body from a truncated entry
+    carve       recover the entries of a disk image to a cache
+    verify      check the integrity of the cache, or fsck
+
+The list and stats commands are used as:
+    simplecache list [-sidecar file] path
+    simplecache stats [-sidecar file] path
+
+    The stats command prints the body size, the response time, the content type and the url of each entry.
+    -sidecar keeps the urls and their metadata in file, stored outside of the cache directory,
+    so that only the entries changed since the previous run are read.
+
+The trim command is used as:
+    simplecache trim [-dry-run] [-max-size bytes] [-before date] [-host pattern] path
+
+    -max-size removes the least recently used entries until the cache size is at most bytes.
+    -before removes the entries last used before date, formatted as 2006-01-02 or RFC 3339.
+    -host removes the entries whose host matches pattern, like *.example.com.
+    -dry-run prints the entries to remove without removing them.
+
+The eviction command is used as:
+    simplecache eviction -max-size bytes [-now date] path
+
+    -max-size is the maximum size of the cache.
+    -now is the date the ages of the entries are computed at, the current date by default.
+
+The merge command is used as:
+    simplecache merge [-host pattern] path src...
+
+    The entries of the simple caches src are copied to the simple cache path, created if missing.
+    An entry replaces the entry of same key in path if it was used more recently.
+    -host copies only the entries whose host matches pattern, like *.example.com.
+
+The migrate command is used as:
+    simplecache migrate -version version path
+
+    -version is the index version of the rewritten cache, from 6 to 9.
+
+The repair command is used as:
+    simplecache repair [-accept-crc] [-dry-run] url path
 
-path is the path to the chromium cache directory.
+    -accept-crc keeps the streams whose data can not be verified, and recomputes their CRC.
+    -dry-run prints the changes without writing them.
+
+The carve command is used as:
+    simplecache carve [-max-size bytes] image path
+
+    The entry files and the sparse files found in the file image, like a disk image
+    or a memory dump, are stored in the simple cache path, created if missing.
+    -max-size is the maximum size of a carved file, 16M by default.
+
+The verify command prints a line for each index file and each entry:
+    ok      name    url
+    error   name    url    problem
+
+    name is the index file name or the entry hash, a line is printed for each problem.
+    The exit status is 1 if a problem is found.
+
+The list, stats, header, body and verify commands stop on the first interrupt signal.
+
+-snapshot copies the simple cache path to a temporary directory, and reads the copy,
+so that a cache in use by a running browser is read consistently. The copy is read-only,
+it is removed once the command is done.
+
+path is the path to the chromium cache directory,
+stored with the simple or the blockfile backend,
+or to the firefox cache2 directory.
 `
 
+var (
+	snapshot = flag.Bool("snapshot", false, "read a copy of the cache")
+
+	listFlags   = flag.NewFlagSet("list", flag.ExitOnError)
+	listSidecar = listFlags.String("sidecar", "", "keep the urls and their metadata in file")
+)
+
+var (
+	trimFlags   = flag.NewFlagSet("trim", flag.ExitOnError)
+	trimDryRun  = trimFlags.Bool("dry-run", false, "print the entries to remove without removing them")
+	trimMaxSize = trimFlags.Uint64("max-size", 0, "remove the least recently used entries until the cache size is at most bytes")
+	trimBefore  = trimFlags.String("before", "", "remove the entries last used before date")
+	trimHost    = trimFlags.String("host", "", "remove the entries whose host matches pattern")
+)
+
+var (
+	evictionFlags   = flag.NewFlagSet("eviction", flag.ExitOnError)
+	evictionMaxSize = evictionFlags.Uint64("max-size", 0, "the maximum size of the cache")
+	evictionNow     = evictionFlags.String("now", "", "the date the ages of the entries are computed at")
+)
+
+var (
+	mergeFlags = flag.NewFlagSet("merge", flag.ExitOnError)
+	mergeHost  = mergeFlags.String("host", "", "copy only the entries whose host matches pattern")
+)
+
+var (
+	migrateFlags   = flag.NewFlagSet("migrate", flag.ExitOnError)
+	migrateVersion = migrateFlags.Uint("version", 0, "the index version of the rewritten cache")
+)
+
+var (
+	repairFlags     = flag.NewFlagSet("repair", flag.ExitOnError)
+	repairAcceptCRC = repairFlags.Bool("accept-crc", false, "keep the streams whose data can not be verified")
+	repairDryRun    = repairFlags.Bool("dry-run", false, "print the changes without writing them")
+)
+
+var (
+	carveFlags   = flag.NewFlagSet("carve", flag.ExitOnError)
+	carveMaxSize = carveFlags.Int64("max-size", carve.DefaultMaxEntrySize, "the maximum size of a carved file")
+)
+
 func main() {
 	log.SetFlags(0)
 
 	var cmd, url, path string
 	parseArgs(&cmd, &url, &path)
 
-	if cmd == "list" {
-		printList(path)
+	if cmd == "merge" {
+		mergeCaches(path, mergeFlags.Args()[1:])
+		return
+	} else if cmd == "migrate" {
+		migrateCache(path)
+		return
+	} else if cmd == "carve" {
+		carveImage(url, path)
+		return
+	}
+
+	backend, err := openBackend(path)
+	if err != nil {
+		log.Fatalf("Unable to open cache: %v", err)
+	}
+	defer backend.Close()
+
+	ctx := interruptContext()
+
+	if cmd == "list" || cmd == "stats" {
+		setSidecar(backend, *listSidecar)
+		if cmd == "list" {
+			printList(ctx, backend)
+		} else {
+			printStats(ctx, backend)
+		}
 
 	} else if cmd == "header" {
-		printHeader(url, path)
+		printHeader(ctx, backend, url)
 
 	} else if cmd == "body" {
-		printBody(url, path)
+		printBody(ctx, backend, url)
+
+	} else if cmd == "rm" {
+		removeEntry(backend, url)
+
+	} else if cmd == "trim" {
+		trimCache(backend)
+
+	} else if cmd == "eviction" {
+		printEviction(backend)
+
+	} else if cmd == "compact" {
+		compactEntry(backend, url)
+
+	} else if cmd == "repair" {
+		repairEntry(backend, url)
+
+	} else if cmd == "recover" {
+		recoverBody(backend, url)
+
+	} else if cmd == "verify" || cmd == "fsck" {
+		verifyCache(ctx, backend)
 
 	} else {
 		log.Fatalf("Unknown command: %s", cmd)
@@ -54,22 +238,125 @@ func main() {
 }
 
 func parseArgs(cmd, url, path *string) {
-	if len(os.Args) == 1 {
+	flag.Usage = func() { log.Print(usage) }
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
 		log.Fatal(usage)
 	}
 
-	*cmd = os.Args[1]
+	*cmd = args[0]
 
-	if *cmd == "list" {
-		*path = os.Args[2]
+	if *cmd == "list" || *cmd == "stats" {
+		listFlags.Parse(args[1:])
+		*path = listFlags.Arg(0)
+	} else if *cmd == "verify" || *cmd == "fsck" {
+		*path = args[1]
+	} else if *cmd == "trim" {
+		trimFlags.Parse(args[1:])
+		*path = trimFlags.Arg(0)
+	} else if *cmd == "eviction" {
+		evictionFlags.Parse(args[1:])
+		*path = evictionFlags.Arg(0)
+	} else if *cmd == "merge" {
+		mergeFlags.Parse(args[1:])
+		if mergeFlags.NArg() < 2 {
+			log.Fatal(usage)
+		}
+		*path = mergeFlags.Arg(0)
+	} else if *cmd == "migrate" {
+		migrateFlags.Parse(args[1:])
+		*path = migrateFlags.Arg(0)
+	} else if *cmd == "repair" {
+		repairFlags.Parse(args[1:])
+		*url = repairFlags.Arg(0)
+		*path = repairFlags.Arg(1)
+	} else if *cmd == "carve" {
+		carveFlags.Parse(args[1:])
+		*url = carveFlags.Arg(0)
+		*path = carveFlags.Arg(1)
 	} else {
-		*url = os.Args[2]
-		*path = os.Args[3]
+		*url = args[1]
+		*path = args[2]
 	}
 }
 
-func printList(path string) {
-	urls, err := simplecache.URLs(path)
+// openBackend opens the cache at path, or a snapshot of the simple cache at path
+// if the flag -snapshot is set.
+func openBackend(path string) (simplecache.Backend, error) {
+	if *snapshot {
+		return simplecache.OpenSnapshot(path)
+	}
+	return simplecache.Open(path)
+}
+
+// interruptContext returns a context canceled on the first SIGINT,
+// a second SIGINT terminates the process.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		cancel()
+	}()
+	return ctx
+}
+
+// keys returns the keys of backend, until ctx is done if backend supports it.
+func keys(ctx context.Context, backend simplecache.Backend) ([]string, error) {
+	if lister, ok := backend.(interface {
+		URLsContext(ctx context.Context) ([]string, error)
+	}); ok {
+		return lister.URLsContext(ctx)
+	}
+	return backend.Keys()
+}
+
+// open returns the record of url, read until ctx is done if backend supports it.
+func open(ctx context.Context, backend simplecache.Backend, url string) (simplecache.Record, error) {
+	if opener, ok := backend.(interface {
+		OpenContext(ctx context.Context, url string) (simplecache.Record, error)
+	}); ok {
+		return opener.OpenContext(ctx, url)
+	}
+	return backend.Open(url)
+}
+
+// openBody returns the body of record, read until ctx is done if record supports it.
+func openBody(ctx context.Context, record simplecache.Record) (io.ReadCloser, error) {
+	if reader, ok := record.(interface {
+		BodyContext(ctx context.Context) (io.ReadCloser, error)
+	}); ok {
+		return reader.BodyContext(ctx)
+	}
+	resp, err := record.Response()
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// readHeader reads the header of record without opening its body,
+// unless record has no Header method.
+func readHeader(record simplecache.Record) (http.Header, error) {
+	if reader, ok := record.(interface {
+		Header() (http.Header, error)
+	}); ok {
+		return reader.Header()
+	}
+	resp, err := record.Response()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp.Header, nil
+}
+
+func printList(ctx context.Context, backend simplecache.Backend) {
+	urls, err := keys(ctx, backend)
 	if err != nil {
 		log.Fatalf("Unable to get urls: %v", err)
 	}
@@ -79,29 +366,70 @@ func printList(path string) {
 	}
 }
 
-func printHeader(url, path string) {
-	entry, err := simplecache.Get(url, path)
+// setSidecar sets the sidecar file of backend, if file is not empty.
+func setSidecar(backend simplecache.Backend, file string) {
+	if file == "" {
+		return
+	}
+	cache, ok := backend.(*simplecache.Cache)
+	if !ok {
+		log.Fatalf("Unable to use sidecar: %T is not a simple cache", backend)
+	}
+	cache.Sidecar = file
+}
+
+func printStats(ctx context.Context, backend simplecache.Backend) {
+	cache, ok := backend.(interface {
+		Stats(ctx context.Context) ([]simplecache.EntryStat, error)
+	})
+	if !ok {
+		log.Fatalf("Unable to get stats: %T is not a simple cache", backend)
+	}
+
+	stats, err := cache.Stats(ctx)
+	if err != nil {
+		log.Fatalf("Unable to get stats: %v", err)
+	}
+
+	var size int64
+	for _, stat := range stats {
+		fmt.Printf("%d\t%s\t%s\t%s\n", stat.BodySize,
+			stat.ResponseTime.UTC().Format(time.RFC3339), stat.ContentType, stat.URL)
+		size += stat.BodySize
+	}
+	log.Printf("Found %d entries, %d bytes", len(stats), size)
+}
+
+func printHeader(ctx context.Context, backend simplecache.Backend, url string) {
+	record, err := open(ctx, backend, url)
 	if err != nil {
 		log.Fatalf("Unable to open entry: %v", err)
 	}
+	defer record.Close()
 
-	header, err := entry.Header()
+	header, err := readHeader(record)
 	if err != nil {
 		log.Fatalf("Unable to read header: %v", err)
 	}
 
+	keys := make([]string, 0, len(header))
 	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
 		fmt.Printf("%s: %s\n", key, header.Get(key))
 	}
 }
 
-func printBody(url, path string) {
-	entry, err := simplecache.Get(url, path)
+func printBody(ctx context.Context, backend simplecache.Backend, url string) {
+	record, err := open(ctx, backend, url)
 	if err != nil {
 		log.Fatalf("Unable to open entry: %v", err)
 	}
+	defer record.Close()
 
-	body, err := entry.Body()
+	body, err := openBody(ctx, record)
 	if err != nil {
 		log.Fatalf("Unable to read body: %v", err)
 	}
@@ -112,3 +440,271 @@ func printBody(url, path string) {
 		log.Fatalf("Unable to copy body to stdout: %v", err)
 	}
 }
+
+func removeEntry(backend simplecache.Backend, url string) {
+	remover, ok := backend.(interface {
+		Remove(key string) error
+	})
+	if !ok {
+		log.Fatalf("Unable to remove entry: %T is read-only", backend)
+	}
+
+	if err := remover.Remove(url); err != nil {
+		log.Fatalf("Unable to remove entry: %v", err)
+	}
+}
+
+func trimCache(backend simplecache.Backend) {
+	trimmer, ok := backend.(interface {
+		Trim(opts simplecache.TrimOptions) ([]simplecache.TrimmedEntry, error)
+	})
+	if !ok {
+		log.Fatalf("Unable to trim cache: %T is read-only", backend)
+	}
+
+	opts := simplecache.TrimOptions{
+		MaxSize: *trimMaxSize,
+		Host:    *trimHost,
+		DryRun:  *trimDryRun,
+	}
+	if *trimBefore != "" {
+		before, err := parseDate(*trimBefore)
+		if err != nil {
+			log.Fatalf("Unable to parse date: %v", err)
+		}
+		opts.Before = before
+	}
+
+	trimmed, err := trimmer.Trim(opts)
+	if err != nil {
+		log.Fatalf("Unable to trim cache: %v", err)
+	}
+
+	var size uint64
+	for _, entry := range trimmed {
+		fmt.Printf("%d\t%s\t%s\n", entry.Size,
+			entry.LastUsed.UTC().Format(time.RFC3339), entry.URL)
+		size += entry.Size
+	}
+
+	if *trimDryRun {
+		log.Printf("Would remove %d entries, %d bytes", len(trimmed), size)
+	} else {
+		log.Printf("Removed %d entries, %d bytes", len(trimmed), size)
+	}
+}
+
+func printEviction(backend simplecache.Backend) {
+	cache, ok := backend.(interface {
+		Evictions(maxSize uint64, now time.Time) ([]simplecache.Eviction, error)
+	})
+	if !ok {
+		log.Fatalf("Unable to simulate eviction: %T is not a simple cache", backend)
+	}
+	if *evictionMaxSize == 0 {
+		log.Fatal("Unable to simulate eviction: -max-size is required")
+	}
+
+	var now time.Time
+	if *evictionNow != "" {
+		var err error
+		now, err = parseDate(*evictionNow)
+		if err != nil {
+			log.Fatalf("Unable to parse date: %v", err)
+		}
+	}
+
+	evictions, err := cache.Evictions(*evictionMaxSize, now)
+	if err != nil {
+		log.Fatalf("Unable to simulate eviction: %v", err)
+	}
+
+	var size uint64
+	for _, eviction := range evictions {
+		fmt.Printf("%d\t%s\t%s\n", eviction.Size,
+			eviction.LastUsed.UTC().Format(time.RFC3339), eviction.URL)
+		size += eviction.Size
+	}
+	log.Printf("Chromium would evict %d entries, %d bytes", len(evictions), size)
+}
+
+func mergeCaches(dir string, sources []string) {
+	var filter func(url string) bool
+	if *mergeHost != "" {
+		var err error
+		if filter, err = simplecache.HostFilter(*mergeHost); err != nil {
+			log.Fatalf("Unable to parse host: %v", err)
+		}
+	}
+
+	dst, err := simplecache.OpenCache(dir)
+	if err != nil {
+		dst, err = simplecache.CreateCache(dir)
+	}
+	if err != nil {
+		log.Fatalf("Unable to open cache: %v", err)
+	}
+
+	var copied int
+	for _, source := range sources {
+		src, err := simplecache.OpenCache(source)
+		if err != nil {
+			log.Fatalf("Unable to open cache: %v", err)
+		}
+
+		n, err := simplecache.Copy(src, dst, filter)
+		if err != nil {
+			log.Fatalf("Unable to merge cache: %v", err)
+		}
+		log.Printf("Copied %d entries from %s", n, source)
+		copied += n
+	}
+	log.Printf("Copied %d entries to %s", copied, dir)
+}
+
+func compactEntry(backend simplecache.Backend, url string) {
+	compacter, ok := backend.(interface {
+		CompactSparse(key string) (int, int, error)
+	})
+	if !ok {
+		log.Fatalf("Unable to compact entry: %T is not a simple cache", backend)
+	}
+
+	before, after, err := compacter.CompactSparse(url)
+	if err != nil {
+		log.Fatalf("Unable to compact entry: %v", err)
+	}
+	log.Printf("Compacted %d ranges into %d ranges", before, after)
+}
+
+func verifyCache(ctx context.Context, backend simplecache.Backend) {
+	verifier, ok := backend.(interface {
+		Verify(ctx context.Context) ([]simplecache.VerifyResult, error)
+	})
+	if !ok {
+		log.Fatalf("Unable to verify cache: %T is not a simple cache", backend)
+	}
+
+	results, err := verifier.Verify(ctx)
+	if err != nil {
+		log.Fatalf("Unable to verify cache: %v", err)
+	}
+
+	var problems int
+	for _, result := range results {
+		if len(result.Problems) == 0 {
+			fmt.Printf("ok\t%s\t%s\n", result.Name, result.URL)
+		}
+		for _, problem := range result.Problems {
+			fmt.Printf("error\t%s\t%s\t%s\n", result.Name, result.URL, problem)
+		}
+		problems += len(result.Problems)
+	}
+
+	log.Printf("Verified %d entries, found %d problems", len(results)-2, problems)
+	if problems > 0 {
+		backend.Close()
+		os.Exit(1)
+	}
+}
+
+func recoverBody(backend simplecache.Backend, url string) {
+	recoverer, ok := backend.(interface {
+		Recover(url string) (*simplecache.RecoveredEntry, error)
+	})
+	if !ok {
+		log.Fatalf("Unable to recover entry: %T is not a simple cache", backend)
+	}
+
+	entry, err := recoverer.Recover(url)
+	if err != nil {
+		log.Fatalf("Unable to recover entry: %v", err)
+	}
+
+	body, err := entry.Body()
+	if err != nil {
+		log.Fatalf("Unable to read body: %v", err)
+	}
+	defer body.Close()
+
+	n, err := io.Copy(os.Stdout, body)
+	if err != nil {
+		log.Fatalf("Unable to copy body to stdout: %v", err)
+	}
+	if entry.Truncated {
+		log.Printf("Entry is truncated, recovered %d bytes", n)
+	}
+}
+
+func repairEntry(backend simplecache.Backend, url string) {
+	repairer, ok := backend.(interface {
+		Repair(key string, opts simplecache.RepairOptions) (simplecache.RepairReport, error)
+	})
+	if !ok {
+		log.Fatalf("Unable to repair entry: %T is not a simple cache", backend)
+	}
+
+	report, err := repairer.Repair(url, simplecache.RepairOptions{
+		AcceptCRC: *repairAcceptCRC,
+		DryRun:    *repairDryRun,
+	})
+	for _, action := range report.Actions {
+		fmt.Println(action)
+	}
+	if err != nil {
+		log.Fatalf("Unable to repair entry: %v", err)
+	}
+
+	if len(report.Actions) == 0 {
+		log.Print("Entry is valid")
+	} else if report.Written {
+		log.Printf("Repaired %s_0", report.Name)
+	} else {
+		log.Printf("Would repair %s_0", report.Name)
+	}
+}
+
+func carveImage(image, dir string) {
+	file, err := os.Open(image)
+	if err != nil {
+		log.Fatalf("Unable to open image: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		log.Fatalf("Unable to open image: %v", err)
+	}
+
+	dst, err := simplecache.OpenCache(dir)
+	if err != nil {
+		dst, err = simplecache.CreateCache(dir)
+	}
+	if err != nil {
+		log.Fatalf("Unable to open cache: %v", err)
+	}
+
+	stats, err := carve.Carve(file, info.Size(), dst, carve.Options{MaxEntrySize: *carveMaxSize})
+	if err != nil {
+		log.Fatalf("Unable to carve image: %v", err)
+	}
+	log.Printf("Recovered %d entries (%d truncated) and %d sparse files, %d invalid candidates, "+
+		"%d truncated candidates without HTTP response info",
+		stats.Entries, stats.Truncated, stats.Sparse, stats.Invalid, stats.Headerless)
+}
+
+func migrateCache(path string) {
+	if *migrateVersion == 0 {
+		log.Fatal("Unable to migrate cache: -version is required")
+	}
+	if err := simplecache.Migrate(path, uint32(*migrateVersion)); err != nil {
+		log.Fatalf("Unable to migrate cache: %v", err)
+	}
+}
+
+func parseDate(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
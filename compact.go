@@ -0,0 +1,322 @@
+package simplecache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// CompactSparse rewrites the sparse file of the entry for key, and returns
+// the number of ranges of the file before and after the rewrite.
+//
+// The parts of the ranges overridden by ranges stored later in the file named
+// "path/hash(key)_s" are dropped, and the adjacent ranges are merged into
+// ranges of recomputed CRC, of at most 1 MiB unless a single range is larger.
+// The file is renamed "path/hash(key)_s.bak" during the rewrite, this backup
+// is restored if the rewritten file can not be verified.
+// The size of the entry is updated in the file named "path/index-dir/the-real-index".
+func (c *Cache) CompactSparse(key string) (int, int, error) {
+	if err := c.checkWritable(); err != nil {
+		return 0, 0, fmt.Errorf("compact %s: %v", key, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index, err := readIndex(c.fsys)
+	if err != nil {
+		return 0, 0, fmt.Errorf("compact %s: %v", key, err)
+	}
+
+	hash := entryHash(key)
+	entry, err := c.getHash(hash)
+	if err != nil {
+		return 0, 0, fmt.Errorf("compact %s: %v", key, err)
+	}
+	// the sparse file is replaced, it is not held open meanwhile
+	entry.Close()
+	if entry.URL != key {
+		return 0, 0, fmt.Errorf("compact %s: key mismatch: %s", key, entry.URL)
+	}
+	if !entry.sparse {
+		return 0, 0, fmt.Errorf("compact %s: entry is not sparse", key)
+	}
+
+	name := filepath.Join(c.path, fmt.Sprintf("%016x_s", hash))
+	before, after, err := compactSparseFile(name, c.limits())
+	if err != nil {
+		return 0, 0, fmt.Errorf("compact %s: %v", key, err)
+	}
+
+	now := time.Now()
+	lastUsed := chromeTime(now)
+	for _, e := range index.Entries {
+		if e.Hash == hash {
+			lastUsed = e.LastUsed
+		}
+	}
+	index.put(indexEntry{
+		Hash:     hash,
+		LastUsed: lastUsed,
+		Size:     index.packSize(uint64(c.filesSize(hash)), 0),
+	})
+	index.Modified = chromeTime(now)
+
+	if err = writeIndex(c.path, index); err != nil {
+		return 0, 0, fmt.Errorf("compact %s: %v", key, err)
+	}
+	return before, after, nil
+}
+
+// compactSparseFile rewrites the sparse file name with its ranges resolved and merged.
+func compactSparseFile(name string, limits Limits) (int, int, error) {
+	backup := name + ".bak"
+	if err := os.Rename(name, backup); err != nil {
+		return 0, 0, fmt.Errorf("backup sparse-file: %v", err)
+	}
+
+	before, after, err := rewriteSparseFile(backup, name, limits)
+	if err != nil {
+		os.Remove(name)
+		if rerr := os.Rename(backup, name); rerr != nil {
+			return 0, 0, fmt.Errorf("%v, restore sparse-file: %v", err, rerr)
+		}
+		return 0, 0, err
+	}
+
+	if err = os.Remove(backup); err != nil {
+		return 0, 0, fmt.Errorf("remove sparse-file backup: %v", err)
+	}
+	return before, after, nil
+}
+
+// rewriteSparseFile writes the sparse file src to dst with its ranges resolved and merged,
+// and verifies dst against src.
+func rewriteSparseFile(src, dst string, limits Limits) (int, int, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, 0, fmt.Errorf("open sparse-file: %v", err)
+	}
+	defer close(in)
+
+	key, err := readSparseHeader(in, limits)
+	if err != nil {
+		return 0, 0, err
+	}
+	headerSize := entryHeaderSize + int64(len(key))
+
+	ranges, err := scan(context.Background(), in, headerSize, limits)
+	if err != nil {
+		return 0, 0, fmt.Errorf("scan sparse-file: %v", err)
+	}
+	for _, rng := range ranges {
+		if err = checkRangeCRC(in, rng); err != nil {
+			return 0, 0, err
+		}
+	}
+	groups := mergeRanges(resolveRanges(ranges))
+
+	out, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, 0, fmt.Errorf("create sparse-file: %v", err)
+	}
+	defer close(out)
+
+	if _, err = io.Copy(out, io.NewSectionReader(in, 0, headerSize)); err != nil {
+		return 0, 0, fmt.Errorf("write sparse-file header: %v", err)
+	}
+	for _, group := range groups {
+		if err = writeMergedRange(out, in, group); err != nil {
+			return 0, 0, err
+		}
+	}
+	if err = out.Sync(); err != nil {
+		return 0, 0, fmt.Errorf("sync sparse-file: %v", err)
+	}
+
+	if err = verifyCompacted(out, headerSize, in, groups, limits); err != nil {
+		return 0, 0, fmt.Errorf("verify sparse-file: %v", err)
+	}
+	return len(ranges), len(groups), nil
+}
+
+// resolveRanges returns the ranges without overlap, sorted by offset.
+// A range overrides the parts of the ranges stored before it in the sparse file.
+func resolveRanges(ranges sparseRanges) sparseRanges {
+	byFile := make(sparseRanges, len(ranges))
+	copy(byFile, ranges)
+	sort.Slice(byFile, func(i, j int) bool {
+		return byFile[i].FileOffset < byFile[j].FileOffset
+	})
+
+	var resolved sparseRanges
+	for _, rng := range byFile {
+		if rng.Len == 0 {
+			continue
+		}
+		end := rng.Offset + rng.Len
+
+		var kept sparseRanges
+		for _, r := range resolved {
+			rEnd := r.Offset + r.Len
+			if rEnd <= rng.Offset || r.Offset >= end {
+				kept = append(kept, r)
+				continue
+			}
+			if r.Offset < rng.Offset {
+				kept = append(kept, sparseRange{
+					Offset:     r.Offset,
+					Len:        rng.Offset - r.Offset,
+					FileOffset: r.FileOffset,
+				})
+			}
+			if rEnd > end {
+				kept = append(kept, sparseRange{
+					Offset:     end,
+					Len:        rEnd - end,
+					FileOffset: r.FileOffset + end - r.Offset,
+				})
+			}
+		}
+		resolved = append(kept, rng)
+	}
+
+	sort.Sort(resolved)
+	return resolved
+}
+
+// maxMergedRangeSize is the maximum size of a range merged by CompactSparse,
+// so that reading a merged range does not need a large buffer.
+const maxMergedRangeSize int64 = 1 << 20
+
+// mergeRanges groups the adjacent ranges, the ranges must be sorted without overlap.
+// A range is not added to a group if the group would exceed maxMergedRangeSize.
+func mergeRanges(ranges sparseRanges) []sparseRanges {
+	var groups []sparseRanges
+	var size int64
+	for i, rng := range ranges {
+		adjacent := i > 0 && ranges[i-1].Offset+ranges[i-1].Len == rng.Offset
+		if adjacent && size+rng.Len <= maxMergedRangeSize {
+			groups[len(groups)-1] = append(groups[len(groups)-1], rng)
+			size += rng.Len
+		} else {
+			groups = append(groups, sparseRanges{rng})
+			size = rng.Len
+		}
+	}
+	return groups
+}
+
+// writeMergedRange appends the adjacent ranges of group, read from src, as one range to dst.
+func writeMergedRange(dst *os.File, src io.ReaderAt, group sparseRanges) error {
+	start, err := dst.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("seek sparse-file: %v", err)
+	}
+
+	header := sparseRangeHeader{
+		Magic:  sparseMagicNumber,
+		Offset: group[0].Offset,
+	}
+	if _, err = dst.Seek(sparseRangeHeaderSize, io.SeekCurrent); err != nil {
+		return fmt.Errorf("seek sparse-file: %v", err)
+	}
+
+	crc := crc32.NewIEEE()
+	for _, rng := range group {
+		section := io.NewSectionReader(src, rng.FileOffset, rng.Len)
+		if _, err = io.Copy(io.MultiWriter(dst, crc), section); err != nil {
+			return fmt.Errorf("write sparse-range: %v", err)
+		}
+		header.Len += rng.Len
+	}
+	header.CRC = crc.Sum32()
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header)
+	if _, err = dst.WriteAt(buf.Bytes(), start); err != nil {
+		return fmt.Errorf("write sparse-range header: %v", err)
+	}
+	return nil
+}
+
+// verifyCompacted verifies that the ranges of the compacted sparse file dst
+// match the groups and hold the same data as the ranges of src.
+func verifyCompacted(dst *os.File, offset int64, src io.ReaderAt,
+	groups []sparseRanges, limits Limits) error {
+	ranges, err := scan(context.Background(), dst, offset, limits)
+	if err != nil {
+		return err
+	}
+	if len(ranges) != len(groups) {
+		return fmt.Errorf("ranges: %d, want: %d", len(ranges), len(groups))
+	}
+
+	for i, rng := range ranges {
+		group := groups[i]
+		last := group[len(group)-1]
+		if rng.Offset != group[0].Offset || rng.Offset+rng.Len != last.Offset+last.Len {
+			return fmt.Errorf("range %d: offset: %d, len: %d, want: %d, %d", i,
+				rng.Offset, rng.Len, group[0].Offset, last.Offset+last.Len-group[0].Offset)
+		}
+		if err = checkRangeCRC(dst, rng); err != nil {
+			return err
+		}
+
+		sections := make([]io.Reader, len(group))
+		for j, r := range group {
+			sections[j] = io.NewSectionReader(src, r.FileOffset, r.Len)
+		}
+		same, err := sameContent(
+			io.NewSectionReader(dst, rng.FileOffset, rng.Len),
+			io.MultiReader(sections...))
+		if err != nil {
+			return err
+		}
+		if !same {
+			return fmt.Errorf("range %d: data differs", i)
+		}
+	}
+	return nil
+}
+
+// checkRangeCRC verifies the CRC of the range rng of the sparse file.
+func checkRangeCRC(file io.ReaderAt, rng sparseRange) error {
+	crc := crc32.NewIEEE()
+	if _, err := io.Copy(crc, io.NewSectionReader(file, rng.FileOffset, rng.Len)); err != nil {
+		return fmt.Errorf("read sparse-range: %v", err)
+	}
+	if actual := crc.Sum32(); rng.CRC != actual {
+		return fmt.Errorf("sparse-range CRC: %x, want: %x", rng.CRC, actual)
+	}
+	return nil
+}
+
+// sameContent reports whether a and b read the same bytes.
+func sameContent(a, b io.Reader) (bool, error) {
+	bufA := make([]byte, 32*1024)
+	bufB := make([]byte, 32*1024)
+	for {
+		n, errA := io.ReadFull(a, bufA)
+		m, errB := io.ReadFull(b, bufB)
+		if !bytes.Equal(bufA[:n], bufB[:m]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
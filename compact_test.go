@@ -0,0 +1,132 @@
+package simplecache_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestCompactSparse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "Cache")
+	cache, err := simplecache.CreateCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := "https://example.com/video.webm"
+	var data []byte
+	for i := 0; i < 100; i++ {
+		chunk := []byte(fmt.Sprintf("chunk%05d", i))
+		if err = cache.WriteSparse(url, int64(len(data)), chunk); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, chunk...)
+	}
+	if err = cache.WriteSparse(url, 2000, []byte("tail")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a range overriding a part of the first ranges
+	names, err := filepath.Glob(filepath.Join(path, "*_s"))
+	if err != nil || len(names) != 1 {
+		t.Fatalf("sparse files: %v, %v", names, err)
+	}
+	appendSparseRange(t, names[0], 5, []byte("XXXXXXXXXX"))
+	copy(data[5:], "XXXXXXXXXX")
+
+	before, after, err := cache.CompactSparse(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != 102 || after != 2 {
+		t.Fatalf("ranges: %d, %d, want: %d, %d", before, after, 102, 2)
+	}
+	testSparseBody(t, cache, url, string(data)+"tail")
+
+	if _, err = os.Stat(names[0] + ".bak"); !os.IsNotExist(err) {
+		t.Fatalf("backup: %v", err)
+	}
+	testRealIndex(t, filepath.Join(path, "index-dir", "the-real-index"), 6, 1)
+
+	// a compacted file is unchanged
+	before, after, err = cache.CompactSparse(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != 2 || after != 2 {
+		t.Fatalf("ranges: %d, %d, want: %d, %d", before, after, 2, 2)
+	}
+
+	url = "https://example.com/"
+	err = cache.Put(url, &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader("body")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = cache.CompactSparse(url); err == nil {
+		t.Fatal("err is nil")
+	}
+}
+
+func TestCompactSparseMaxSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Cache")
+	cache, err := simplecache.CreateCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the merged ranges are at most 1 MiB, a larger range is kept
+	url := "https://example.com/video.webm"
+	var data []byte
+	for i, size := range []int{300 << 10, 300 << 10, 300 << 10, 300 << 10, 300 << 10, 2 << 20} {
+		chunk := bytes.Repeat([]byte{byte('a' + i)}, size)
+		if err = cache.WriteSparse(url, int64(len(data)), chunk); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, chunk...)
+	}
+
+	before, after, err := cache.CompactSparse(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != 6 || after != 3 {
+		t.Fatalf("ranges: %d, %d, want: %d, %d", before, after, 6, 3)
+	}
+	testSparseBody(t, cache, url, string(data))
+}
+
+func appendSparseRange(t *testing.T, name string, offset int64, data []byte) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint64(0xeb97bf016553676b))
+	binary.Write(&buf, binary.LittleEndian, offset)
+	binary.Write(&buf, binary.LittleEndian, int64(len(data)))
+	binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(data))
+	buf.Write(data)
+
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err = file.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,159 @@
+package simplecache
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Copy copies the entries of src selected by filter to dst, and returns the number of copied entries.
+// A nil filter selects every entry, otherwise filter is called with the URL of each entry.
+//
+// The files named "src/hash_0", "src/hash_1" and "src/hash_s" of an entry replace
+// the files of the entry of same hash in dst, unless the entry of dst was used more
+// recently. The file named "dst/index-dir/the-real-index" is updated once every entry is copied.
+func Copy(src, dst *Cache, filter func(url string) bool) (int, error) {
+	if err := dst.checkWritable(); err != nil {
+		return 0, fmt.Errorf("copy to %s: %v", dst.path, err)
+	}
+
+	srcIndex, err := readIndex(src.fsys)
+	if err != nil {
+		return 0, fmt.Errorf("copy %s: %v", src.path, err)
+	}
+
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+
+	dstIndex, err := readIndex(dst.fsys)
+	if err != nil {
+		return 0, fmt.Errorf("copy to %s: %v", dst.path, err)
+	}
+	lastUsed := make(map[uint64]int64, len(dstIndex.Entries))
+	for _, entry := range dstIndex.Entries {
+		lastUsed[entry.Hash] = entry.LastUsed
+	}
+
+	var copied int
+	for _, entry := range srcIndex.Entries {
+		if used, ok := lastUsed[entry.Hash]; ok && used >= entry.LastUsed {
+			continue
+		}
+		if filter != nil {
+			url, err := src.readURL(entry.Hash)
+			if err != nil || !filter(url) {
+				continue
+			}
+		}
+
+		size, err := copyEntryFiles(src.fsys, dst, entry.Hash)
+		if err != nil {
+			return copied, fmt.Errorf("copy to %s: %v", dst.path, err)
+		}
+
+		dstIndex.put(indexEntry{
+			Hash:     entry.Hash,
+			LastUsed: entry.LastUsed,
+			Size:     dstIndex.packSize(uint64(size), 0),
+		})
+		copied++
+	}
+
+	if copied == 0 {
+		return 0, nil
+	}
+
+	dstIndex.Modified = chromeTime(time.Now())
+	if err = writeIndex(dst.path, dstIndex); err != nil {
+		return copied, fmt.Errorf("copy to %s: %v", dst.path, err)
+	}
+	return copied, nil
+}
+
+// copyEntryFiles copies the existing entry files of hash from src to dst,
+// and returns their total size.
+//
+// The files are copied to temporary files first, so that the entry of dst is
+// kept if a file can not be copied. The temporary files are then renamed over
+// the entry files of dst, and the files of dst missing from src are deleted.
+func copyEntryFiles(src fs.FS, dst *Cache, hash uint64) (int64, error) {
+	var size int64
+	var missing []string
+	temps := make(map[string]string)
+	defer func() {
+		for _, temp := range temps {
+			os.Remove(temp)
+		}
+	}()
+
+	for _, suffix := range []string{"_0", "_1", "_s"} {
+		temp, n, err := copyTemp(src, fmt.Sprintf("%016x%s", hash, suffix), dst.path)
+		if os.IsNotExist(err) {
+			missing = append(missing, suffix)
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		temps[suffix] = temp
+		size += n
+	}
+
+	if _, err := dst.removeFiles(hash, missing...); err != nil {
+		return 0, err
+	}
+	for _, suffix := range []string{"_0", "_1", "_s"} {
+		temp, ok := temps[suffix]
+		if !ok {
+			continue
+		}
+		name := filepath.Join(dst.path, fmt.Sprintf("%016x%s", hash, suffix))
+		if err := os.Rename(temp, name); err != nil {
+			return 0, err
+		}
+		delete(temps, suffix)
+	}
+	return size, nil
+}
+
+// copyFile copies the file name of src to a temporary file renamed to dst once written.
+func copyFile(src fs.FS, name, dst string) (int64, error) {
+	temp, n, err := copyTemp(src, name, filepath.Dir(dst))
+	if err != nil {
+		return 0, err
+	}
+	if err = os.Rename(temp, dst); err != nil {
+		os.Remove(temp)
+		return 0, err
+	}
+	return n, nil
+}
+
+// copyTemp copies the file name of src to a temporary file of the directory dir,
+// and returns the name of the temporary file.
+func copyTemp(src fs.FS, name, dir string) (string, int64, error) {
+	in, err := src.Open(name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer close(in)
+
+	out, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return "", 0, err
+	}
+
+	n, err := io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", 0, err
+	}
+	return out.Name(), n, nil
+}
@@ -0,0 +1,150 @@
+package simplecache_test
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestCopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "Cache")
+	dst, err := simplecache.CreateCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := simplecache.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := func(url string) bool {
+		return strings.HasPrefix(url, "https://golang.org/pkg/")
+	}
+	for _, want := range []int{9, 0} {
+		copied, err := simplecache.Copy(src, dst, pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if copied != want {
+			t.Fatalf("copied: %d, want: %d", copied, want)
+		}
+	}
+
+	copied, err := simplecache.Copy(src, dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 10 {
+		t.Fatalf("copied: %d, want: %d", copied, 10)
+	}
+
+	urls, err := src.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range urls {
+		testEntry(t, url, path)
+		testSameBody(t, src, dst, url)
+	}
+	testRealIndex(t, filepath.Join(path, "index-dir", "the-real-index"), 6, 19)
+
+	// a more recent entry replaces the entry of same hash
+	newer, err := simplecache.CreateCache(filepath.Join(dir, "Newer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "https://golang.org/doc/gopher/pkg.png"
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Length": {"5"}},
+		Body:       ioutil.NopCloser(strings.NewReader("hello")),
+	}
+	if err = newer.Put(url, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		src  *simplecache.Cache
+		want int
+	}{{newer, 1}, {src, 0}} {
+		copied, err = simplecache.Copy(c.src, dst, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if copied != c.want {
+			t.Fatalf("copied: %d, want: %d", copied, c.want)
+		}
+	}
+	testEntry(t, url, path)
+	testSameBody(t, newer, dst, url)
+	testRealIndex(t, filepath.Join(path, "index-dir", "the-real-index"), 6, 19)
+}
+
+func TestCopyFailed(t *testing.T) {
+	path := copyCache(t, "testdata")
+	defer os.RemoveAll(path)
+
+	dst, err := simplecache.OpenCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "https://golang.org/doc/gopher/pkg.png"
+	want := readBody(t, dst, url)
+
+	newer := filepath.Join(t.TempDir(), "Cache")
+	cache, err := simplecache.CreateCache(newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cache.Put(url, newResponse([]byte("newer"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the entry file of src can not be read
+	src, err := simplecache.OpenCacheFS(failFS{os.DirFS(newer), "bb9d1cda868d278c_0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = simplecache.Copy(src, dst, nil); err == nil {
+		t.Fatal("err is nil")
+	}
+
+	// the entry of dst is kept
+	if body := readBody(t, dst, url); !bytes.Equal(body, want) {
+		t.Fatalf("body: %d bytes, want: %d bytes", len(body), len(want))
+	}
+	files, err := filepath.Glob(filepath.Join(path, ".tmp-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("temporary files: %v", files)
+	}
+}
+
+// failFS is a fs.FS whose file name can not be opened.
+type failFS struct {
+	fs.FS
+	name string
+}
+
+func (f failFS) Open(name string) (fs.File, error) {
+	if name == f.name {
+		return nil, errors.New("open failed")
+	}
+	return f.FS.Open(name)
+}
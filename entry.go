@@ -2,17 +2,18 @@ package simplecache
 
 import (
 	"bytes"
-	"crypto/sha1"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"hash/crc32"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // Entry represents a HTTP response as stored in the cache.
@@ -20,61 +21,258 @@ import (
 type Entry struct {
 	URL       string
 	hash      uint64
-	path      string
+	fsys      fs.FS
 	fileSize  int64
 	keyLen    int64
+	sparse    bool
+	name1     string
 	offset1   int64
 	dataSize1 int64
+	name0     string
 	offset0   int64
 	dataSize0 int64
+	data      []byte      // the mapped file "path/hash(url)_0", see Cache.Mmap
+	mapped    bool        // data is mapped by mmapFile, it is released by Close
+	file0     file        // the file name0 held open since Get, nil if data is set
+	stat0     fs.FileInfo // the stat of name0 at Get, checked when name0 is opened again
+	fileS     file        // the file "path/hash(url)_s" held open since Get, if sparse
+	sizeS     int64       // the size of fileS when it was opened
+	limits    Limits      // the limits of the cache, with the defaults for its zero fields
 }
 
 // Get returns the Entry for the specified URL.
+// The cache backend is detected as described in Open.
 // An error is returned if the format of the entry does not match the one expected.
+//
+// The entry file "path/hash(url)_0" of a simple cache is held open until the Entry
+// is closed, see Cache.Get: the caller must call Close once the entry is read.
 func Get(url, path string) (*Entry, error) {
-	sum := sha1.Sum([]byte(url))
-	hash := binary.LittleEndian.Uint64(sum[:8])
+	cache, err := Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s: %v", url, err)
+	}
+	defer cache.Close()
+
+	record, err := cache.Open(url)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := record.(*Entry)
+	if !ok {
+		return nil, fmt.Errorf("getting %s: unexpected record %T", url, record)
+	}
+	return entry, nil
+}
+
+// Get returns the Entry for the specified URL.
+// An error is returned if the format of the entry does not match the one expected.
+//
+// The files of the entry are held open until Close, so that the entry is read consistently
+// even if Chromium replaces or deletes them meanwhile. The files replaced while Get opens
+// them are opened again.
+func (c *Cache) Get(url string) (*Entry, error) {
+	return c.GetContext(context.Background(), url)
+}
 
-	name := filepath.Join(path, fmt.Sprintf("%016x_0", hash))
-	file, err := os.Open(name)
+// GetContext returns the Entry for the specified URL, see Get.
+// An error is returned if ctx is done before the entry and its sparse file are opened,
+// see Entry.BodyContext to read its body until ctx is done.
+func (c *Cache) GetContext(ctx context.Context, url string) (*Entry, error) {
+	entry, err := c.getHashContext(ctx, entryHash(url))
 	if err != nil {
 		return nil, fmt.Errorf("getting %s: %v", url, err)
 	}
-	defer close(file)
+	if entry.URL != url {
+		entry.Close()
+		return nil, fmt.Errorf("getting %s: key mismatch: %s", url, entry.URL)
+	}
+	return entry, nil
+}
+
+// openRetries is the number of times getHash opens the files of an entry replaced meanwhile.
+const openRetries = 3
+
+// errEntryChanged is returned when an entry file is replaced or modified while it is read.
+var errEntryChanged = errors.New("entry file changed")
+
+// getHash returns the Entry stored in the file named "path/hash_0", see Get.
+func (c *Cache) getHash(hash uint64) (*Entry, error) {
+	return c.getHashContext(context.Background(), hash)
+}
+
+// getHashContext is getHash, it returns ctx.Err() once ctx is done.
+func (c *Cache) getHashContext(ctx context.Context, hash uint64) (*Entry, error) {
+	if c.Mmap {
+		return c.getMapped(ctx, hash)
+	}
+
+	var err error
+	for i := 0; i < openRetries; i++ {
+		var entry *Entry
+		if entry, err = c.openEntry(ctx, hash); err != errEntryChanged {
+			return entry, err
+		}
+	}
+	return nil, err
+}
+
+// openEntry opens and reads the files of the entry of hash.
+// errEntryChanged is returned if the file "path/hash_0" is replaced before they are opened.
+func (c *Cache) openEntry(ctx context.Context, hash uint64) (*Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("%016x_0", hash)
+	file, err := openFile(c.fsys, name)
+	if err != nil {
+		return nil, err
+	}
 
 	stat, err := file.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("getting %s: %v", url, err)
+		close(file)
+		return nil, err
 	}
 
 	entry := Entry{
 		hash:     hash,
-		path:     path,
+		fsys:     c.fsys,
 		fileSize: stat.Size(),
+		name0:    name,
+		name1:    name,
+		file0:    file,
+		stat0:    stat,
+		limits:   c.limits(),
 	}
 
-	if err = entry.readHeader(file); err != nil {
-		return nil, fmt.Errorf("getting %s: %v", url, err)
+	if err = entry.read(ctx, file); err != nil {
+		entry.Close()
+		return nil, err
 	}
-	if err = entry.readStream0(file); err != nil {
-		return nil, fmt.Errorf("getting %s: %v", url, err)
+	if !sameFile(c.fsys, name, stat) {
+		entry.Close()
+		return nil, errEntryChanged
 	}
-	if err = entry.readStream1(file); err != nil {
-		return nil, fmt.Errorf("getting %s: %v", url, err)
+	return &entry, nil
+}
+
+// read reads the header and the streams of an entry file, and opens its sparse file.
+// ctx is checked between each step.
+func (e *Entry) read(ctx context.Context, file file) error {
+	if err := e.readHeader(file); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := e.readStream0(file); err != nil {
+		return err
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := e.readStream1(file); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.openSparse()
+}
 
-	return &entry, nil
+// openSparse opens the file "path/hash_s" of an entry without body, which is then sparse.
+// The ranges appended to the sparse file once it is opened are not read.
+func (e *Entry) openSparse() error {
+	if e.dataSize1 > 0 {
+		return nil
+	}
+	file, err := openFile(e.fsys, fmt.Sprintf("%016x_s", e.hash))
+	if err != nil {
+		return nil
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		close(file)
+		return err
+	}
+	e.sparse, e.fileS, e.sizeS = true, file, stat.Size()
+	return nil
+}
+
+// Close closes the files of the entry held open since Get, see Get, and releases the file
+// mapped when Cache.Mmap is set. The files are opened again by name if the entry is read
+// after Close, an error is returned if the entry file was replaced or modified meanwhile.
+func (e *Entry) Close() error {
+	var err error
+	for _, f := range []file{e.file0, e.fileS} {
+		if f == nil {
+			continue
+		}
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	e.file0, e.fileS = nil, nil
+
+	if e.mapped {
+		if uerr := munmapFile(e.data); uerr != nil && err == nil {
+			err = fmt.Errorf("munmap: %v", uerr)
+		}
+	}
+	e.data, e.mapped = nil, false
+	return err
 }
 
-func readURL(hash uint64, path string) (string, error) {
-	name := filepath.Join(path, fmt.Sprintf("%016x_0", hash))
-	file, err := os.Open(name)
+// open opens the file name of the entry, the held file if any, see Get.
+// errEntryChanged is returned if the size of the held file changed,
+// or if the file name0 opened again after Close is not the file read by Get.
+func (e *Entry) open(name string) (file, error) {
+	if e.file0 == nil || name != e.name0 {
+		f, err := openFile(e.fsys, name)
+		if err != nil || name != e.name0 || e.stat0 == nil {
+			return f, err
+		}
+		stat, err := f.Stat()
+		if err != nil || !sameStat(e.stat0, stat) {
+			close(f)
+			return nil, errEntryChanged
+		}
+		return f, nil
+	}
+	stat, err := e.file0.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() != e.fileSize {
+		return nil, errEntryChanged
+	}
+	return shareFile(e.file0, e.fileSize), nil
+}
+
+// responseTime returns the time the response was received, as a Chromium internal time value.
+func (e *Entry) responseTime() (int64, error) {
+	stream0, err := e.readStream(e.name0, e.offset0, e.dataSize0)
+	if err != nil {
+		return 0, fmt.Errorf("read response time: %v", err)
+	}
+
+	var meta responseInfo
+	err = binary.Read(bytes.NewReader(stream0), binary.LittleEndian, &meta)
+	if err != nil {
+		return 0, fmt.Errorf("read response time: %v", err)
+	}
+	return meta.ResponseTime, nil
+}
+
+func (c *Cache) readURL(hash uint64) (string, error) {
+	file, err := openFile(c.fsys, fmt.Sprintf("%016x_0", hash))
 	if err != nil {
 		return "", fmt.Errorf("readurl %016x_0: %v", hash, err)
 	}
 	defer close(file)
 
-	var entry Entry
+	entry := Entry{limits: c.limits()}
 	err = entry.readHeader(file)
 	if err != nil {
 		return "", fmt.Errorf("readurl %016x_0: %v", hash, err)
@@ -99,6 +297,9 @@ func (e *Entry) readHeader(file io.Reader) error {
 	}
 
 	// keyLen
+	if err = checkSize("header key length", int64(header.KeyLen), e.limits.MaxKeySize); err != nil {
+		return err
+	}
 	e.keyLen = int64(header.KeyLen)
 
 	key := make([]byte, header.KeyLen)
@@ -119,7 +320,7 @@ func (e *Entry) readHeader(file io.Reader) error {
 	return nil
 }
 
-func (e *Entry) readStream0(file *os.File) error {
+func (e *Entry) readStream0(file file) error {
 	var stream0EOF entryEOF
 
 	_, err := file.Seek(-1*entryEOFSize, io.SeekEnd)
@@ -139,23 +340,26 @@ func (e *Entry) readStream0(file *os.File) error {
 
 	// dataSize0
 	e.dataSize0 = int64(stream0EOF.StreamSize)
+	if err = checkSize("stream0 size", e.dataSize0, e.limits.MaxHeaderSize); err != nil {
+		return err
+	}
 
 	// offset0
 	e.offset0 = e.fileSize - entryEOFSize - e.dataSize0
 	if stream0EOF.HasSHA256() {
 		e.offset0 -= int64(sha256.Size)
 	}
+	if e.offset0 < entryHeaderSize+e.keyLen+entryEOFSize {
+		return fmt.Errorf("stream0 size: %d, file size: %d", e.dataSize0, e.fileSize)
+	}
 
 	// verifyStream0
 
 	if stream0EOF.HasCRC32() {
-		stream0 := make([]byte, e.dataSize0)
-		_, err = file.ReadAt(stream0, e.offset0)
+		actualCRC, err := checksum(file, e.offset0, e.dataSize0)
 		if err != nil {
 			return fmt.Errorf("read stream0: %v", err)
 		}
-
-		actualCRC := crc32.ChecksumIEEE(stream0)
 		if stream0EOF.CRC != actualCRC {
 			return fmt.Errorf("stream0 CRC: %x, want: %x",
 				stream0EOF.CRC, actualCRC)
@@ -181,7 +385,7 @@ func (e *Entry) readStream0(file *os.File) error {
 	return nil
 }
 
-func (e *Entry) readStream1(file *os.File) error {
+func (e *Entry) readStream1(file file) error {
 	var stream1EOF entryEOF
 
 	_, err := file.Seek(e.offset0-entryEOFSize, io.SeekStart)
@@ -201,19 +405,21 @@ func (e *Entry) readStream1(file *os.File) error {
 
 	// dataSize1
 	e.dataSize1 = int64(stream1EOF.StreamSize)
-
+	if err = checkSize("stream1 size", e.dataSize1, e.limits.MaxStreamSize); err != nil {
+		return err
+	}
+	if entryHeaderSize+e.keyLen+e.dataSize1 > e.offset0-entryEOFSize {
+		return fmt.Errorf("stream1 size: %d, file size: %d", e.dataSize1, e.fileSize)
+	}
 	// offset1
 	e.offset1 = entryHeaderSize + e.keyLen
 
 	// verifyStream1
 	if e.dataSize1 > 0 && stream1EOF.HasCRC32() {
-		stream1 := make([]byte, e.dataSize1)
-		_, err := file.ReadAt(stream1, e.offset1)
+		actualCRC, err := checksum(file, e.offset1, e.dataSize1)
 		if err != nil {
 			return fmt.Errorf("read stream1: %v", err)
 		}
-
-		actualCRC := crc32.ChecksumIEEE(stream1)
 		if stream1EOF.CRC != actualCRC {
 			return fmt.Errorf("stream1 CRC: %x, want: %x",
 				stream1EOF.CRC, actualCRC)
@@ -223,43 +429,158 @@ func (e *Entry) readStream1(file *os.File) error {
 	return nil
 }
 
+// Key returns the URL of the entry.
+func (e *Entry) Key() string {
+	return e.URL
+}
+
 // Header returns the HTTP header.
 func (e *Entry) Header() (http.Header, error) {
-	name := filepath.Join(e.path, fmt.Sprintf("%016x_0", e.hash))
-	file, err := os.Open(name)
+	stream0, err := e.readStream(e.name0, e.offset0, e.dataSize0)
+	if err != nil {
+		return nil, fmt.Errorf("read header: %v", err)
+	}
+	return parseHeader(stream0, e.limits)
+}
+
+// Response returns the HTTP response, its body is read as described in Body.
+func (e *Entry) Response() (*http.Response, error) {
+	stream0, err := e.readStream(e.name0, e.offset0, e.dataSize0)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %v", err)
+	}
+	resp, err := parseResponse(stream0, e.limits)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body, err = e.Body()
 	if err != nil {
-		return nil, fmt.Errorf("open header: %v", err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Stream returns the stream index of the entry.
+// Stream 0 is the pickled HTTP response info and stream 1 is the body.
+func (e *Entry) Stream(index int) (io.ReadCloser, error) {
+	switch index {
+	case 0:
+		return e.openStream(e.name0, e.offset0, e.dataSize0)
+	case 1:
+		return e.Body()
+	}
+	return nil, fmt.Errorf("stream %d: not supported", index)
+}
+
+func (e *Entry) readStream(name string, offset, size int64) ([]byte, error) {
+	if err := checkSize("stream size", size, e.limits.MaxStreamSize); err != nil {
+		return nil, err
+	}
+	if e.data != nil {
+		return e.data[offset : offset+size : offset+size], nil
+	}
+
+	file, err := e.open(name)
+	if err != nil {
+		return nil, err
 	}
 	defer close(file)
 
-	stream0 := make([]byte, e.dataSize0)
-	_, err = file.ReadAt(stream0, e.offset0)
+	stream := make([]byte, size)
+	_, err = file.ReadAt(stream, offset)
 	if err != nil {
-		return nil, fmt.Errorf("read header: %v", err)
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (e *Entry) openStream(name string, offset, size int64) (io.ReadCloser, error) {
+	if size == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
 	}
+	if e.data != nil {
+		return bytesReader{bytes.NewReader(e.data[offset : offset+size])}, nil
+	}
+
+	file, err := e.open(name)
+	if err != nil {
+		return nil, fmt.Errorf("open stream: %v", err)
+	}
+
+	return &streamReader{
+		SectionReader: io.NewSectionReader(file, offset, size),
+		file:          file,
+	}, nil
+}
+
+// streamReader reads a stream of an entry file.
+type streamReader struct {
+	*io.SectionReader
+	file io.Closer
+}
+
+func (sr streamReader) Close() error {
+	return sr.file.Close()
+}
+
+// contextReader returns ctx.Err() once ctx is done.
+type contextReader struct {
+	io.ReadCloser
+	ctx context.Context
+}
 
-	var meta struct {
-		InfoSize     int32
-		Flag         int32
-		RequestTime  int64
-		ResponseTime int64
-		HeaderSize   int32
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
 	}
+	return cr.ReadCloser.Read(p)
+}
+
+// responseInfo is the beginning of the pickled HTTP response info (stream 0).
+type responseInfo struct {
+	InfoSize     int32
+	Flag         int32
+	RequestTime  int64
+	ResponseTime int64
+	HeaderSize   int32
+}
+
+// rawHeader returns the NUL separated HTTP header lines from the pickled stream 0 of an entry.
+func rawHeader(stream0 []byte, limits Limits) ([][]byte, error) {
+	var meta responseInfo
 
 	reader := bytes.NewReader(stream0)
-	err = binary.Read(reader, binary.LittleEndian, &meta)
+	err := binary.Read(reader, binary.LittleEndian, &meta)
 	if err != nil {
 		return nil, fmt.Errorf("read header metadata: %v", err)
 	}
 
-	buf := make([]byte, meta.HeaderSize)
+	size := int64(meta.HeaderSize)
+	if err = checkSize("header size", size, min64(limits.MaxHeaderSize, int64(reader.Len()))); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
 	err = binary.Read(reader, binary.LittleEndian, buf)
 	if err != nil {
 		return nil, fmt.Errorf("read header size: %v", err)
 	}
 
+	return bytes.Split(buf, []byte{0}), nil
+}
+
+// parseHeader parses the HTTP header from the pickled stream 0 of an entry.
+func parseHeader(stream0 []byte, limits Limits) (http.Header, error) {
+	lines, err := rawHeader(stream0, limits)
+	if err != nil {
+		return nil, err
+	}
+	return headerLines(lines), nil
+}
+
+func headerLines(lines [][]byte) http.Header {
 	header := make(http.Header)
-	lines := bytes.Split(buf, []byte{0})
 
 	for _, line := range lines {
 		kv := bytes.SplitN(line, []byte{':'}, 2)
@@ -270,35 +591,93 @@ func (e *Entry) Header() (http.Header, error) {
 		}
 	}
 
-	return header, nil
+	return header
 }
 
-// Body returns the HTTP body.
-// Body may read a file named "path/hash(url)_s".
-func (e *Entry) Body() (io.ReadCloser, error) {
-	if e.dataSize1 == 0 {
-		return newSparseReader(e.hash, e.path)
+// parseResponse parses the HTTP status line and header from the pickled stream 0 of an entry.
+func parseResponse(stream0 []byte, limits Limits) (*http.Response, error) {
+	lines, err := rawHeader(stream0, limits)
+	if err != nil {
+		return nil, err
 	}
+	return newResponse(lines[0], headerLines(lines[1:]))
+}
 
-	name := filepath.Join(e.path, fmt.Sprintf("%016x_0", e.hash))
-	file, err := os.Open(name)
+// newResponse returns a HTTP response from its status line, like "HTTP/1.1 200 OK".
+func newResponse(status []byte, header http.Header) (*http.Response, error) {
+	fields := strings.SplitN(string(status), " ", 3)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("malformed status line: %q", status)
+	}
+
+	major, minor, ok := http.ParseHTTPVersion(fields[0])
+	if fields[0] == "HTTP/2" || fields[0] == "HTTP/3" {
+		major, minor, ok = int(fields[0][5]-'0'), 0, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("malformed HTTP version: %q", fields[0])
+	}
+	code, err := strconv.Atoi(fields[1])
 	if err != nil {
-		return nil, fmt.Errorf("open body: %v", err)
+		return nil, fmt.Errorf("malformed HTTP status code: %q", fields[1])
 	}
-	defer close(file)
 
-	stream1 := make([]byte, e.dataSize1)
-	_, err = file.ReadAt(stream1, e.offset1)
+	resp := http.Response{
+		Status:        strings.TrimSpace(strings.Join(fields[1:], " ")),
+		StatusCode:    code,
+		Proto:         fields[0],
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        header,
+		ContentLength: -1,
+	}
+	if length := header.Get("Content-Length"); length != "" {
+		if n, err := strconv.ParseInt(length, 10, 64); err == nil {
+			resp.ContentLength = n
+		}
+	}
+	return &resp, nil
+}
+
+// Body returns the HTTP body.
+// Body may read a file named "path/hash(url)_s".
+func (e *Entry) Body() (io.ReadCloser, error) {
+	return e.body(context.Background())
+}
+
+// BodyContext returns the HTTP body, see Body.
+// Reading the body returns ctx.Err() once ctx is done,
+// including while a range of a sparse body is read.
+func (e *Entry) BodyContext(ctx context.Context) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	body, err := e.body(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("read body: %v", err)
+		return nil, err
 	}
+	return contextReader{ReadCloser: body, ctx: ctx}, nil
+}
 
-	reader := bytes.NewReader(stream1)
-	return ioutil.NopCloser(reader), nil
+// body returns the HTTP body, the ranges of a sparse body are read until ctx is done.
+func (e *Entry) body(ctx context.Context) (io.ReadCloser, error) {
+	if e.sparse {
+		var file file
+		if e.fileS != nil {
+			file = shareFile(e.fileS, e.sizeS)
+		} else {
+			var err error
+			if file, err = openFile(e.fsys, fmt.Sprintf("%016x_s", e.hash)); err != nil {
+				return nil, fmt.Errorf("open sparse-file: %v", err)
+			}
+		}
+		return newSparseReader(ctx, file, e.limits)
+	}
+	return e.openStream(e.name1, e.offset1, e.dataSize1)
 }
 
-func close(f *os.File) {
+func close(f io.Closer) {
 	if err := f.Close(); err != nil {
-		log.Printf("Error closing file %s: %v\n", f.Name(), err)
+		log.Printf("Error closing file: %v\n", err)
 	}
 }
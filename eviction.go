@@ -0,0 +1,102 @@
+package simplecache
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// evictionMarginDivisor sets the watermarks of the cache size:
+	// eviction starts above maxSize - maxSize/20 and stops below maxSize - 2*maxSize/20.
+	evictionMarginDivisor uint64 = 20
+	// estimatedEntryOverhead is added to the size of an entry to weight its eviction score.
+	estimatedEntryOverhead uint64 = 512
+)
+
+// Eviction is an entry that Chromium would evict.
+// URL is empty if the entry file could not be read.
+type Eviction struct {
+	URL      string
+	Size     uint64
+	LastUsed time.Time
+	// Score is the age of the entry in seconds, multiplied by its size;
+	// the entries of highest scores are evicted first.
+	Score uint64
+	hash  uint64
+}
+
+// Evictions returns the entries that Chromium would evict next, in eviction order,
+// if the maximum size of the cache was maxSize bytes. The ages of the entries are
+// computed at now, or at the current time if now is zero.
+//
+// Like the Chromium simple backend, nothing is evicted while the cache size is
+// below the high watermark (95% of maxSize); otherwise the entries of highest
+// scores are evicted until the cache size is below the low watermark (90% of maxSize).
+//
+// Evictions reads the file named "path/index-dir/the-real-index" and the
+// entries files named "path/hash_0" of the evicted entries, nothing is removed.
+func (c *Cache) Evictions(maxSize uint64, now time.Time) ([]Eviction, error) {
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	index, err := readIndex(c.fsys)
+	if err != nil {
+		return nil, fmt.Errorf("evictions %s: %v", c.path, err)
+	}
+
+	var size uint64
+	for _, entry := range index.Entries {
+		size += index.entrySize(entry)
+	}
+
+	highWatermark := maxSize - maxSize/evictionMarginDivisor
+	lowWatermark := maxSize - 2*(maxSize/evictionMarginDivisor)
+	if size <= highWatermark {
+		return nil, nil
+	}
+
+	candidates := make([]Eviction, len(index.Entries))
+	for i, entry := range index.Entries {
+		candidates[i] = Eviction{
+			Size:     index.entrySize(entry),
+			LastUsed: fromChromeTime(entry.LastUsed),
+			hash:     entry.Hash,
+		}
+		candidates[i].Score = evictionScore(candidates[i], now)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+
+	var evictions []Eviction
+	var freed uint64
+	for _, eviction := range candidates {
+		if freed >= size-lowWatermark {
+			break
+		}
+		if url, err := c.readURL(eviction.hash); err == nil {
+			eviction.URL = url
+		}
+		freed += eviction.Size
+		evictions = append(evictions, eviction)
+	}
+	return evictions, nil
+}
+
+// evictionScore returns the age of the entry in seconds at now,
+// multiplied by its size plus an estimated overhead.
+func evictionScore(eviction Eviction, now time.Time) uint64 {
+	age := now.Sub(eviction.LastUsed) / time.Second
+	if age < 0 {
+		age = 0
+	}
+
+	size := eviction.Size + estimatedEntryOverhead
+	if uint64(age) > math.MaxUint64/size {
+		return math.MaxUint64
+	}
+	return uint64(age) * size
+}
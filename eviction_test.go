@@ -0,0 +1,45 @@
+package simplecache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestEvictions(t *testing.T) {
+	cache, err := simplecache.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2016, 7, 18, 0, 0, 0, 0, time.UTC)
+
+	evictions, err := cache.Evictions(2000000, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evictions) != 0 {
+		t.Fatalf("evictions: %d, want: 0", len(evictions))
+	}
+
+	evictions, err = cache.Evictions(500000, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js",
+		"https://ssl.google-analytics.com/ga.js",
+	}
+	if len(evictions) != len(want) {
+		t.Fatalf("evictions: %d, want: %d", len(evictions), len(want))
+	}
+	for i := range want {
+		if evictions[i].URL != want[i] {
+			t.Fatalf("eviction: %s, want: %s", evictions[i].URL, want[i])
+		}
+		if i > 0 && evictions[i].Score > evictions[i-1].Score {
+			t.Fatalf("eviction score: %d, want: <= %d",
+				evictions[i].Score, evictions[i-1].Score)
+		}
+	}
+}
@@ -14,6 +14,7 @@ func Example() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer entry.Close()
 	fmt.Println(entry.URL)
 
 	header, err := entry.Header()
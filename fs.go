@@ -0,0 +1,91 @@
+package simplecache
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+)
+
+// file is a file of a fs.FS, read at any offset.
+type file interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Closer
+	Stat() (fs.FileInfo, error)
+}
+
+// openFile opens the file name of fsys.
+// A file that does not implement io.ReaderAt and io.Seeker,
+// like a compressed file of a zip archive, is read in memory.
+func openFile(fsys fs.FS, name string) (file, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if rf, ok := f.(file); ok {
+		return rf, nil
+	}
+	defer close(f)
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return memFile{Reader: bytes.NewReader(data), info: info}, nil
+}
+
+// memFile is a file read in memory.
+type memFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f memFile) Close() error {
+	return nil
+}
+
+func (f memFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// sharedFile reads a file held open by an Entry, from its own offset.
+// Close does not close the held file, see Entry.Close.
+type sharedFile struct {
+	*io.SectionReader
+	file file
+}
+
+// shareFile returns a sharedFile reading the first size bytes of f.
+func shareFile(f file, size int64) sharedFile {
+	return sharedFile{SectionReader: io.NewSectionReader(f, 0, size), file: f}
+}
+
+func (f sharedFile) Close() error {
+	return nil
+}
+
+func (f sharedFile) Stat() (fs.FileInfo, error) {
+	return f.file.Stat()
+}
+
+// sameFile reports whether the file name of fsys is still the file of info, unmodified:
+// with the same size and modification time, and the same file for os.SameFile
+// if info describes a file of the operating system.
+func sameFile(fsys fs.FS, name string, info fs.FileInfo) bool {
+	current, err := fs.Stat(fsys, name)
+	return err == nil && sameStat(info, current)
+}
+
+// sameStat reports whether info and current describe the same file, unmodified, see sameFile.
+func sameStat(info, current fs.FileInfo) bool {
+	if current.Size() != info.Size() || !current.ModTime().Equal(info.ModTime()) {
+		return false
+	}
+	return !os.SameFile(info, info) || os.SameFile(info, current)
+}
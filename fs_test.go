@@ -0,0 +1,163 @@
+package simplecache_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestOpenFS(t *testing.T) {
+	for _, path := range []string{"testdata", "testdata/blockfile", "testdata/cache2"} {
+		want, err := simplecache.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testBackendFS(t, want, mapFS(t, path))
+
+		zipFS, err := fs.Sub(zipArchive(t, path), "cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testBackendFS(t, want, zipFS)
+	}
+}
+
+func TestOpenCacheFSReadOnly(t *testing.T) {
+	cache, err := simplecache.OpenCacheFS(mapFS(t, "testdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "https://golang.org/doc/gopher/pkg.png"
+
+	if err = cache.Remove(url); err == nil {
+		t.Fatal("remove: err is nil")
+	}
+	if err = cache.WriteSparse(url, 0, []byte("sparse")); err == nil {
+		t.Fatal("write sparse: err is nil")
+	}
+	if _, err = cache.Repair(url, simplecache.RepairOptions{DryRun: true}); err != nil {
+		t.Fatal(err)
+	}
+	results, err := cache.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testProblems(t, results, map[string]int{})
+}
+
+// testBackendFS verifies that the backend opened from fsys has the same entries as want.
+func testBackendFS(t *testing.T, want simplecache.Backend, fsys fs.FS) {
+	backend, err := simplecache.OpenFS(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	wantKeys, err := want.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := backend.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != len(wantKeys) {
+		t.Fatalf("%T: keys: %d, want: %d", backend, len(keys), len(wantKeys))
+	}
+
+	for _, key := range wantKeys {
+		body := readRecordBody(t, backend, key)
+		if !bytes.Equal(body, readRecordBody(t, want, key)) {
+			t.Fatalf("%T: %s: body differs", backend, key)
+		}
+	}
+}
+
+func readRecordBody(t *testing.T, backend simplecache.Backend, key string) []byte {
+	record, err := backend.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := record.Response()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// mapFS reads the files of the directory src in memory.
+func mapFS(t *testing.T, src string) fstest.MapFS {
+	fsys := make(fstest.MapFS)
+	walkFiles(t, src, func(rel string, data []byte) {
+		fsys[rel] = &fstest.MapFile{Data: data, Mode: 0600}
+	})
+	return fsys
+}
+
+// zipArchive writes the files of the directory src to a zip archive, in the directory "cache".
+func zipArchive(t *testing.T, src string) *zip.Reader {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	walkFiles(t, src, func(rel string, data []byte) {
+		f, err := w.Create("cache/" + rel)
+		if err == nil {
+			_, err = f.Write(data)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// walkFiles calls fn with the slash-separated name relative to src and the content
+// of each file of the directory src, the nested caches of testdata are skipped.
+func walkFiles(t *testing.T, src string, fn func(rel string, data []byte)) {
+	err := filepath.Walk(src, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if name != src && filepath.Dir(rel) == "." && rel != "index-dir" && rel != "entries" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		fn(filepath.ToSlash(rel), data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,248 @@
+package simplecache_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/schorlet/simplecache"
+)
+
+// fuzzURL is the URL of the entry whose files are replaced by the fuzzed data,
+// its files are named "bb9d1cda868d278c_#".
+const fuzzURL = "https://golang.org/doc/gopher/pkg.png"
+
+func FuzzDecodeEntry(f *testing.F) {
+	addFiles(f, filepath.Join("testdata", "*_0"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		entry, err := simplecache.DecodeEntry(data)
+		if err != nil {
+			return
+		}
+		readRecord(entry)
+	})
+}
+
+func FuzzDecodeSparse(f *testing.F) {
+	f.Add(sparseFile(f))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		simplecache.DecodeSparse(data)
+	})
+}
+
+func FuzzEntryFile(f *testing.F) {
+	addFiles(f, filepath.Join("testdata", "*_0"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		path := filepath.Join(t.TempDir(), "Cache")
+		cache, err := simplecache.CreateCache(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeEntryFile(t, path, "_0", data)
+
+		if entry, err := cache.Get(fuzzURL); err == nil {
+			readRecord(entry)
+		}
+		if entry, err := cache.Recover(fuzzURL); err == nil {
+			readRecord(entry)
+		}
+		cache.Verify(context.Background())
+
+		cache.Mmap = true
+		if entry, err := cache.Get(fuzzURL); err == nil {
+			readRecord(entry)
+			entry.BodyBytes()
+			entry.Close()
+		}
+	})
+}
+
+func FuzzSparseFile(f *testing.F) {
+	f.Add(sparseFile(f))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		path := filepath.Join(t.TempDir(), "Cache")
+		cache, err := simplecache.CreateCache(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = cache.WriteSparse(fuzzURL, 0, []byte("sparse")); err != nil {
+			t.Fatal(err)
+		}
+		writeEntryFile(t, path, "_s", data)
+
+		if entry, err := cache.Get(fuzzURL); err == nil {
+			readRecord(entry)
+		}
+		cache.Verify(context.Background())
+	})
+}
+
+func FuzzIndex(f *testing.F) {
+	addFiles(f, filepath.Join("testdata", "index-dir", "the-real-index"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		path := filepath.Join(t.TempDir(), "Cache")
+		cache, err := simplecache.CreateCache(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Join(path, "index-dir", "the-real-index")
+		if err = ioutil.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		cache.URLs()
+		cache.Walk(func(entry *simplecache.Entry, info simplecache.EntryInfo, err error) error {
+			return nil
+		})
+		cache.Evictions(1, time.Time{})
+		cache.Verify(context.Background())
+	})
+}
+
+func FuzzBlockfile(f *testing.F) {
+	index, err := ioutil.ReadFile(filepath.Join("testdata", "blockfile", "index"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	data1, err := ioutil.ReadFile(filepath.Join("testdata", "blockfile", "data_1"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(index, data1)
+
+	f.Fuzz(func(t *testing.T, index, data1 []byte) {
+		path := copyDir(t, filepath.Join("testdata", "blockfile"))
+		if err := ioutil.WriteFile(filepath.Join(path, "index"), index, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(path, "data_1"), data1, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		cache, err := simplecache.OpenBlockfile(path)
+		if err != nil {
+			return
+		}
+		readBackend(cache)
+	})
+}
+
+func FuzzCache2(f *testing.F) {
+	addFiles(f, filepath.Join("testdata", "cache2", "entries", "*"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		path := t.TempDir()
+		if err := os.Mkdir(filepath.Join(path, "entries"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Join(path, "entries", "00835A4730D4EABC3B89236682868FB970EC5C11")
+		if err := ioutil.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		cache, err := simplecache.OpenCache2(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readBackend(cache)
+	})
+}
+
+// addFiles adds the content of the files matching pattern to the seed corpus.
+func addFiles(f *testing.F, pattern string) {
+	names, err := filepath.Glob(pattern)
+	if err != nil || len(names) == 0 {
+		f.Fatalf("glob %s: %v, %v", pattern, names, err)
+	}
+	for _, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+}
+
+// sparseFile returns a sparse file of two ranges.
+func sparseFile(f *testing.F) []byte {
+	path := filepath.Join(f.TempDir(), "Cache")
+	cache, err := simplecache.CreateCache(path)
+	if err != nil {
+		f.Fatal(err)
+	}
+	if err = cache.WriteSparse(fuzzURL, 0, []byte("sparse")); err != nil {
+		f.Fatal(err)
+	}
+	if err = cache.WriteSparse(fuzzURL, 10, []byte("data")); err != nil {
+		f.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(path, "bb9d1cda868d278c_s"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	return data
+}
+
+// writeEntryFile writes data to the entry file of fuzzURL ending with suffix.
+func writeEntryFile(t *testing.T, path, suffix string, data []byte) {
+	name := filepath.Join(path, "bb9d1cda868d278c"+suffix)
+	if err := ioutil.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// copyDir copies the files of the directory src to a temporary directory.
+func copyDir(t *testing.T, src string) string {
+	dst := t.TempDir()
+	names, err := filepath.Glob(filepath.Join(src, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dst, filepath.Base(name)), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dst
+}
+
+// readBackend reads every record of backend.
+func readBackend(backend simplecache.Backend) {
+	keys, err := backend.Keys()
+	if err != nil {
+		return
+	}
+	for _, key := range keys {
+		if record, err := backend.Open(key); err == nil {
+			readRecord(record)
+		}
+	}
+}
+
+// readRecord reads the response and the streams of record, and closes it.
+func readRecord(record simplecache.Record) {
+	defer record.Close()
+	if resp, err := record.Response(); err == nil {
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	for index := 0; index < 2; index++ {
+		if stream, err := record.Stream(index); err == nil {
+			ioutil.ReadAll(stream)
+			stream.Close()
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package simplecache
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
@@ -17,24 +21,59 @@ import (
 //
 // URLs reads the files named "path/index" and "path/index-dir/the-real-index"
 // and every entry files named "path/hash(url)_0" where hash is read from the-real-index file.
+// Caches stored with the blockfile backend are read as described in Blockfile.URLs.
 //
 // An error is returned if the format of the index files is unexpected.
 func URLs(path string) ([]string, error) {
+	cache, err := Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("get urls from %s: %v", path, err)
+	}
+	defer cache.Close()
+
+	return cache.Keys()
+}
+
+// URLs returns all the URLs currently stored in the cache.
+//
+// URLs reads the file named "path/index-dir/the-real-index"
+// and every entry files named "path/hash(url)_0" where hash is read from the-real-index file.
+// The entry files are read by c.Workers goroutines, and the URLs are returned in the index order.
+// If c.Sidecar is set, the URLs of the unchanged entries are read from the sidecar file, see Stats.
+func (c *Cache) URLs() ([]string, error) {
+	return c.URLsContext(context.Background())
+}
+
+// URLsContext returns all the URLs currently stored in the cache, see URLs.
+// No more entry file is read once ctx is done, and ctx.Err() is returned.
+func (c *Cache) URLsContext(ctx context.Context) ([]string, error) {
 	var urls []string
-	if err := checkFakeIndex(path); err != nil {
-		return urls, fmt.Errorf("get urls from %s: %v", path, err)
+	if c.Sidecar != "" {
+		stats, err := c.Stats(ctx)
+		if err != nil {
+			return urls, fmt.Errorf("get urls from %s: %v", c.path, err)
+		}
+		urls = make([]string, len(stats))
+		for i, stat := range stats {
+			urls[i] = stat.URL
+		}
+		return urls, nil
 	}
 
-	hashes, err := readRealIndex(path)
+	hashes, err := readRealIndex(c.fsys)
 	if err != nil {
-		return urls, fmt.Errorf("get urls from %s: %v", path, err)
+		return urls, fmt.Errorf("get urls from %s: %v", c.path, err)
 	}
-	urls = make([]string, 0, len(hashes))
 
-	for i := 0; i < len(hashes); i++ {
-		url, err := readURL(hashes[i], path)
-		if err != nil {
-			log.Printf("Unable to get %s from %s: %v\n", url, path, err)
+	keys, errs, err := c.readURLs(ctx, hashes)
+	if err != nil {
+		return urls, fmt.Errorf("get urls from %s: %v", c.path, err)
+	}
+
+	urls = make([]string, 0, len(hashes))
+	for i, url := range keys {
+		if errs[i] != nil {
+			log.Printf("Unable to get url from %s: %v\n", c.path, errs[i])
 			continue
 		}
 		urls = append(urls, url)
@@ -43,78 +82,152 @@ func URLs(path string) ([]string, error) {
 }
 
 // checkFakeIndex verifies the index file format.
-func checkFakeIndex(path string) error {
-	info, err := os.Stat(path)
+func checkFakeIndex(fsys fs.FS) error {
+	_, err := readFakeIndex(fsys)
+	return err
+}
+
+// readFakeIndex reads and verifies the index file.
+func readFakeIndex(fsys fs.FS) (fakeIndex, error) {
+	var index fakeIndex
+	info, err := fs.Stat(fsys, ".")
 	if err != nil {
-		return fmt.Errorf("fake-index: %v", err)
+		return index, fmt.Errorf("fake-index: %v", err)
 	}
 	if !info.IsDir() {
-		return fmt.Errorf("fake-index: not a directory")
+		return index, fmt.Errorf("fake-index: not a directory")
 	}
 
-	file, err := os.Open(filepath.Join(path, "index"))
+	file, err := openFile(fsys, "index")
 	if err != nil {
-		return fmt.Errorf("open fake-index: %v", err)
+		return index, fmt.Errorf("open fake-index: %v", err)
 	}
 	defer close(file)
 
-	var index fakeIndex
 	err = binary.Read(file, binary.LittleEndian, &index)
 	if err != nil {
-		return fmt.Errorf("read fake-index: %v", err)
+		return index, fmt.Errorf("read fake-index: %v", err)
 	}
 
 	if index.Magic != initialMagicNumber {
-		return fmt.Errorf("fake-index magic: %x, want: %x",
+		return index, fmt.Errorf("fake-index magic: %x, want: %x",
 			index.Magic, initialMagicNumber)
 	}
 	if index.Version < indexVersion {
-		return fmt.Errorf("fake-index version: %d, want: >= %d",
+		return index, fmt.Errorf("fake-index version: %d, want: >= %d",
 			index.Version, indexVersion)
 	}
-	return nil
+	return index, nil
 }
 
 // readRealIndex reads every index-entries in "the-real-index" file.
-func readRealIndex(path string) ([]uint64, error) {
+func readRealIndex(fsys fs.FS) ([]uint64, error) {
 	var hashes []uint64
 
-	name := filepath.Join(path, "index-dir", "the-real-index")
-	file, err := os.Open(name)
+	index, err := readIndex(fsys)
 	if err != nil {
-		return hashes, fmt.Errorf("open real-index: %v", err)
+		return hashes, err
+	}
+
+	hashes = make([]uint64, len(index.Entries))
+	for i, entry := range index.Entries {
+		hashes[i] = entry.Hash
+	}
+	return hashes, nil
+}
+
+// readIndex reads the "the-real-index" file.
+func readIndex(fsys fs.FS) (*realIndex, error) {
+	file, err := openFile(fsys, "index-dir/the-real-index")
+	if err != nil {
+		return nil, fmt.Errorf("open real-index: %v", err)
 	}
 	defer close(file)
 
-	var index indexHeader
-	err = binary.Read(file, binary.LittleEndian, &index)
+	var index realIndex
+	err = binary.Read(file, binary.LittleEndian, &index.Header)
 	if err != nil {
-		return hashes, fmt.Errorf("read real-index header: %v", err)
+		return nil, fmt.Errorf("read real-index header: %v", err)
 	}
 
-	if err := checkRealIndex(index); err != nil {
-		return hashes, fmt.Errorf("check real-index header: %v", err)
+	if err := checkRealIndex(index.Header); err != nil {
+		return nil, fmt.Errorf("check real-index header: %v", err)
 	}
-	if index.Version > indexVersion {
-		var reasonSize int64 = 4 // last write reason
-		_, err = file.Seek(reasonSize, io.SeekCurrent)
+	if index.Header.Version > indexVersion {
+		err = binary.Read(file, binary.LittleEndian, &index.Reason)
 		if err != nil {
-			return hashes, fmt.Errorf("read real-index 'last write reason': %v", err)
+			return nil, fmt.Errorf("read real-index 'last write reason': %v", err)
 		}
 	}
 
-	hashes = make([]uint64, index.EntryCount)
-	var entry indexEntry
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat real-index: %v", err)
+	}
+	if count := (stat.Size() - indexHeaderSize) / indexEntrySize; index.Header.EntryCount > uint64(count) {
+		return nil, fmt.Errorf("real-index entry count: %d, want: <= %d",
+			index.Header.EntryCount, count)
+	}
+
+	index.Entries = make([]indexEntry, index.Header.EntryCount)
+	err = binary.Read(file, binary.LittleEndian, index.Entries)
+	if err != nil {
+		return nil, fmt.Errorf("read real-index entry: %v", err)
+	}
 
-	for i := uint64(0); i < index.EntryCount; i++ {
-		err = binary.Read(file, binary.LittleEndian, &entry)
-		if err != nil {
-			return nil, fmt.Errorf("read real-index entry: %v", err)
-		}
-		hashes[i] = entry.Hash
+	err = binary.Read(file, binary.LittleEndian, &index.Modified)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("read real-index 'last modified': %v", err)
 	}
 
-	return hashes, nil
+	return &index, nil
+}
+
+// writeIndex writes the "the-real-index" file.
+// The entry count, the cache size, the payload size and its CRC are computed from index.Entries.
+func writeIndex(path string, index *realIndex) error {
+	index.Header.Magic = indexMagicNumber
+	index.Header.EntryCount = uint64(len(index.Entries))
+	index.Header.CacheSize = 0
+	for _, entry := range index.Entries {
+		index.Header.CacheSize += index.entrySize(entry)
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, index.Header)
+	if index.Header.Version > indexVersion {
+		binary.Write(&buf, binary.LittleEndian, index.Reason)
+	}
+	binary.Write(&buf, binary.LittleEndian, index.Entries)
+	binary.Write(&buf, binary.LittleEndian, index.Modified)
+
+	// the payload starts after the Payload and CRC fields
+	data := buf.Bytes()
+	payload := data[8:]
+	binary.LittleEndian.PutUint32(data[0:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(data[4:], crc32.ChecksumIEEE(payload))
+
+	dir := filepath.Join(path, "index-dir")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("write real-index: %v", err)
+	}
+	if err := writeFile(filepath.Join(dir, "the-real-index"), data); err != nil {
+		return fmt.Errorf("write real-index: %v", err)
+	}
+	return nil
+}
+
+// writeFakeIndex writes the "index" file.
+func writeFakeIndex(path string, version uint32) error {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, fakeIndex{
+		Magic:   initialMagicNumber,
+		Version: version,
+	})
+	if err := writeFile(filepath.Join(path, "index"), buf.Bytes()); err != nil {
+		return fmt.Errorf("write fake-index: %v", err)
+	}
+	return nil
 }
 
 // checkRealIndex verifies the "the-real-index" header.
@@ -0,0 +1,67 @@
+package simplecache
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// Limits bounds the sizes read from the cache files, so that a corrupt or hostile
+// file is rejected with an error instead of causing a huge allocation.
+// Every size is also checked against the size of the file it is read from.
+// A zero field is the default limit.
+type Limits struct {
+	// MaxKeySize is the maximum size of a key, in bytes, 2 MiB by default.
+	MaxKeySize int64
+	// MaxHeaderSize is the maximum size of the HTTP response info (stream 0),
+	// or of the metadata of a Firefox entry, in bytes, 1 MiB by default.
+	MaxHeaderSize int64
+	// MaxStreamSize is the maximum size of a stream or of a sparse range, in bytes,
+	// math.MaxInt32 by default.
+	MaxStreamSize int64
+}
+
+// defaultLimits are the limits used for the zero fields of a Limits,
+// and by DecodeEntry and DecodeSparse.
+var defaultLimits = Limits{
+	MaxKeySize:    2 << 20, // the maximum URL length of Chromium
+	MaxHeaderSize: 1 << 20,
+	MaxStreamSize: math.MaxInt32,
+}
+
+// orDefault returns l with its zero fields set to the default limits.
+func (l Limits) orDefault() Limits {
+	if l.MaxKeySize == 0 {
+		l.MaxKeySize = defaultLimits.MaxKeySize
+	}
+	if l.MaxHeaderSize == 0 {
+		l.MaxHeaderSize = defaultLimits.MaxHeaderSize
+	}
+	if l.MaxStreamSize == 0 {
+		l.MaxStreamSize = defaultLimits.MaxStreamSize
+	}
+	return l
+}
+
+// checkSize verifies that size is between 0 and max.
+func checkSize(name string, size, max int64) error {
+	if size < 0 || size > max {
+		return fmt.Errorf("%s: %d, want: 0 to %d", name, size, max)
+	}
+	return nil
+}
+
+// checksum returns the CRC32 of the size bytes of r at offset,
+// without reading them all in memory.
+func checksum(r io.ReaderAt, offset, size int64) (uint32, error) {
+	crc := crc32.NewIEEE()
+	n, err := io.Copy(crc, io.NewSectionReader(r, offset, size))
+	if err != nil {
+		return 0, err
+	}
+	if n != size {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return crc.Sum32(), nil
+}
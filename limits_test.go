@@ -0,0 +1,69 @@
+package simplecache_test
+
+import (
+	"testing"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestLimits(t *testing.T) {
+	url := "https://golang.org/doc/gopher/pkg.png"
+	cache, err := simplecache.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cache.Get(url); err != nil {
+		t.Fatal(err)
+	}
+
+	// the zero fields are the default limits, on both read paths
+	for _, mmap := range []bool{false, true} {
+		cache.Mmap = mmap
+		for _, limits := range []simplecache.Limits{
+			{MaxKeySize: 10},
+			{MaxHeaderSize: 100},
+			{MaxStreamSize: 1000},
+		} {
+			cache.Limits = limits
+			if _, err = cache.Get(url); err == nil {
+				t.Fatalf("mmap: %t, limits: %+v: err is nil", mmap, limits)
+			}
+		}
+	}
+
+	cache.Limits = simplecache.Limits{MaxStreamSize: 1 << 20}
+	entry, err := cache.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Close()
+}
+
+func TestLimitsBackends(t *testing.T) {
+	blockfile, err := simplecache.OpenBlockfile("testdata/blockfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache2, err := simplecache.OpenCache2("testdata/cache2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, backend := range []simplecache.Backend{blockfile, cache2} {
+		keys, err := backend.Keys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) == 0 {
+			t.Fatalf("%T: no keys", backend)
+		}
+	}
+
+	blockfile.Limits = simplecache.Limits{MaxKeySize: 10}
+	cache2.Limits = simplecache.Limits{MaxKeySize: 10}
+	for _, backend := range []simplecache.Backend{blockfile, cache2} {
+		if keys, err := backend.Keys(); err == nil && len(keys) != 0 {
+			t.Fatalf("%T: keys: %d, want: 0", backend, len(keys))
+		}
+	}
+}
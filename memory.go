@@ -0,0 +1,117 @@
+package simplecache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// Memory is a Backend holding its entries in memory, mostly useful for tests.
+type Memory struct {
+	mu      sync.Mutex
+	keys    []string
+	records map[string]*memoryRecord
+}
+
+// NewMemory returns an empty Memory backend.
+func NewMemory() *Memory {
+	return &Memory{records: make(map[string]*memoryRecord)}
+}
+
+// Put stores the response for key, replacing any previous entry.
+// The response body is read until EOF and closed.
+func (m *Memory) Put(key string, resp *http.Response) error {
+	var body []byte
+	if resp.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(resp.Body)
+		if cerr := resp.Body.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("put %s: %v", key, err)
+		}
+	}
+
+	var header bytes.Buffer
+	fmt.Fprintf(&header, "HTTP/%d.%d %d", resp.ProtoMajor, resp.ProtoMinor, resp.StatusCode)
+	for name, values := range resp.Header {
+		for _, value := range values {
+			fmt.Fprintf(&header, "\x00%s: %s", name, value)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.records[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.records[key] = &memoryRecord{
+		key:     key,
+		streams: [][]byte{header.Bytes(), body},
+	}
+	return nil
+}
+
+// Keys returns the keys of all the entries, in insertion order.
+func (m *Memory) Keys() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]string, len(m.keys))
+	copy(keys, m.keys)
+	return keys, nil
+}
+
+// Open returns the entry stored for key.
+func (m *Memory) Open(key string) (Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.records[key]
+	if !ok {
+		return nil, fmt.Errorf("getting %s: entry not found", key)
+	}
+	return record, nil
+}
+
+// Close implements Backend, it has nothing to release.
+func (m *Memory) Close() error {
+	return nil
+}
+
+// memoryRecord is an entry of a Memory backend.
+// Stream 0 holds the NUL separated status line and header lines.
+type memoryRecord struct {
+	key     string
+	streams [][]byte
+}
+
+func (r *memoryRecord) Key() string {
+	return r.key
+}
+
+func (r *memoryRecord) Response() (*http.Response, error) {
+	lines := bytes.Split(r.streams[0], []byte{0})
+	resp, err := newResponse(lines[0], headerLines(lines[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("read response: %v", err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(r.streams[1]))
+	return resp, nil
+}
+
+func (r *memoryRecord) Stream(index int) (io.ReadCloser, error) {
+	if index < 0 || index >= len(r.streams) {
+		return nil, fmt.Errorf("stream %d: not supported", index)
+	}
+	return ioutil.NopCloser(bytes.NewReader(r.streams[index])), nil
+}
+
+func (r *memoryRecord) Close() error {
+	return nil
+}
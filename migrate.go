@@ -0,0 +1,152 @@
+package simplecache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Migrate rewrites the cache at path to the index version, from 6 to 9.
+//
+// Every entry is version 5, whatever the index version. The files named
+// "path/hash_0" are re-encoded with new EOF records: starting index v7, the
+// SHA-256 of the key follows stream 0, it is dropped for index v6.
+// The files named "path/hash_1" are kept as is, and so are the files named
+// "path/hash_s" but the version of their header, which is the index version.
+//
+// Every entry is re-encoded to a temporary file before any file of the cache is
+// replaced, the entries which can not be read are logged and left as is.
+// The file named "path/index-dir/the-real-index" is then rewritten with the
+// sizes of the entry files, and the fake index file named "path/index" last.
+// The last used times of the entries are kept.
+func Migrate(path string, version uint32) error {
+	if version < indexVersion || version > indexVersionLast {
+		return fmt.Errorf("migrate %s: version: %d, want: %d to %d",
+			path, version, indexVersion, indexVersionLast)
+	}
+
+	cache := Cache{path: path, fsys: os.DirFS(path)}
+	index, err := readIndex(cache.fsys)
+	if err != nil {
+		return fmt.Errorf("migrate %s: %v", path, err)
+	}
+
+	var entries []migratedEntry
+	defer func() {
+		for _, m := range entries {
+			os.Remove(m.temp)
+		}
+	}()
+	for _, ie := range index.Entries {
+		data, sparse, err := encodeMigrated(&cache, ie.Hash, version)
+		if err != nil {
+			log.Printf("Unable to migrate %016x from %s: %v\n", ie.Hash, path, err)
+			continue
+		}
+		temp, err := writeTemp(path, data)
+		if err != nil {
+			return fmt.Errorf("migrate %s: %016x: %v", path, ie.Hash, err)
+		}
+		entries = append(entries, migratedEntry{hash: ie.Hash, temp: temp, sparse: sparse})
+	}
+
+	for _, m := range entries {
+		if err = os.Rename(m.temp, filepath.Join(path, fmt.Sprintf("%016x_0", m.hash))); err != nil {
+			return fmt.Errorf("migrate %s: %016x: %v", path, m.hash, err)
+		}
+		if m.sparse {
+			name := filepath.Join(path, fmt.Sprintf("%016x_s", m.hash))
+			if err = migrateSparseHeader(name, version, cache.limits()); err != nil {
+				return fmt.Errorf("migrate %s: %016x: %v", path, m.hash, err)
+			}
+		}
+	}
+
+	files, err := scanEntryFiles(cache.fsys)
+	if err != nil {
+		return fmt.Errorf("migrate %s: %v", path, err)
+	}
+	sizes := make(map[uint64]int64, len(files))
+	for _, f := range files {
+		sizes[f.hash] = f.size
+	}
+
+	migrated := realIndex{
+		Header:   indexHeader{Version: version},
+		Reason:   index.Reason,
+		Entries:  make([]indexEntry, len(index.Entries)),
+		Modified: chromeTime(time.Now()),
+	}
+	for i, entry := range index.Entries {
+		var inMemory uint64
+		if index.Header.Version >= indexVersionPacked {
+			inMemory = entry.Size & 0xff
+		}
+		migrated.Entries[i] = indexEntry{
+			Hash:     entry.Hash,
+			LastUsed: entry.LastUsed,
+			Size:     migrated.packSize(uint64(sizes[entry.Hash]), inMemory),
+		}
+	}
+
+	if err = writeIndex(path, &migrated); err != nil {
+		return fmt.Errorf("migrate %s: %v", path, err)
+	}
+	if err = writeFakeIndex(path, version); err != nil {
+		return fmt.Errorf("migrate %s: %v", path, err)
+	}
+	return nil
+}
+
+// migratedEntry is an entry file "hash_0" re-encoded to the temporary file temp.
+type migratedEntry struct {
+	hash   uint64
+	temp   string
+	sparse bool
+}
+
+// encodeMigrated returns the entry file "hash_0" re-encoded for the index version,
+// and whether the entry is sparse.
+func encodeMigrated(c *Cache, hash uint64, version uint32) ([]byte, bool, error) {
+	e, err := c.getHash(hash)
+	if err != nil {
+		return nil, false, err
+	}
+	defer e.Close()
+
+	stream0, err := e.readStream(e.name0, e.offset0, e.dataSize0)
+	if err != nil {
+		return nil, false, fmt.Errorf("read stream0: %v", err)
+	}
+	var stream1 []byte
+	if !e.sparse {
+		stream1, err = e.readStream(e.name1, e.offset1, e.dataSize1)
+		if err != nil {
+			return nil, false, fmt.Errorf("read stream1: %v", err)
+		}
+	}
+	return encodeEntry(e.URL, stream0, stream1, version >= keySHA256Version, true), e.sparse, nil
+}
+
+// migrateSparseHeader writes the version in the EntryHeader of the sparse file name.
+func migrateSparseHeader(name string, version uint32, limits Limits) error {
+	file, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer close(file)
+
+	if _, err = readSparseHeader(file, limits); err != nil {
+		return err
+	}
+
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], version)
+	if _, err = file.WriteAt(buf[:], 8); err != nil {
+		return fmt.Errorf("write sparse-file version: %v", err)
+	}
+	return nil
+}
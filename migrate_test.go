@@ -0,0 +1,112 @@
+package simplecache_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestMigrate(t *testing.T) {
+	path := copyCache(t, "testdata")
+	defer os.RemoveAll(path)
+
+	urls, err := simplecache.URLs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(path, "index-dir", "the-real-index")
+
+	for _, version := range []uint32{9, 7, 8, 6} {
+		if err = simplecache.Migrate(path, version); err != nil {
+			t.Fatal(err)
+		}
+		testRealIndex(t, name, version, len(urls))
+		testFakeIndex(t, path, version)
+
+		for _, url := range urls {
+			testEntry(t, url, path)
+		}
+	}
+
+	// the v6 entries are encoded like the original ones
+	entries, err := filepath.Glob(filepath.Join("testdata", "*_0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		want, err := ioutil.ReadFile(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(path, filepath.Base(entry)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, want) {
+			t.Fatalf("%s: migrated entry differs", filepath.Base(entry))
+		}
+	}
+
+	if err = simplecache.Migrate(path, 10); err == nil {
+		t.Fatal("err is nil")
+	}
+}
+
+func TestMigrateCorrupt(t *testing.T) {
+	path := copyCache(t, "testdata")
+	defer os.RemoveAll(path)
+
+	urls, err := simplecache.URLs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "https://golang.org/doc/gopher/pkg.png"
+	corruptEntry(t, path, url, func(data []byte) []byte {
+		data[len(data)/2] ^= 0xff
+		return data
+	})
+	name := filepath.Join(path, "bb9d1cda868d278c_0")
+	want, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the corrupt entry is left as is, the other entries are migrated
+	if err = simplecache.Migrate(path, 9); err != nil {
+		t.Fatal(err)
+	}
+	testRealIndex(t, filepath.Join(path, "index-dir", "the-real-index"), 9, len(urls))
+	testFakeIndex(t, path, 9)
+	for _, u := range urls {
+		if u != url {
+			testEntry(t, u, path)
+		}
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Fatal("corrupt entry migrated")
+	}
+
+	temps, err := filepath.Glob(filepath.Join(path, ".tmp-*"))
+	if err != nil || len(temps) != 0 {
+		t.Fatalf("temporary files: %v, %v", temps, err)
+	}
+}
+
+func testFakeIndex(t *testing.T, path string, version uint32) {
+	data, err := ioutil.ReadFile(filepath.Join(path, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := binary.LittleEndian.Uint32(data[8:]); v != version {
+		t.Fatalf("fake-index version: %d, want: %d", v, version)
+	}
+}
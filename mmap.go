@@ -0,0 +1,91 @@
+package simplecache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+)
+
+// mapFile maps the file f in memory, it returns true if the file is mapped and must be
+// released with munmapFile. A file which is not an *os.File, or which can not be mapped,
+// is read in memory.
+func mapFile(f fs.File) ([]byte, bool, error) {
+	if osf, ok := f.(*os.File); ok {
+		if data, err := mmapFile(osf); err == nil {
+			return data, true, nil
+		}
+	}
+	data, err := ioutil.ReadAll(f)
+	return data, false, err
+}
+
+// getMapped returns the Entry stored in the file named "path/hash_0", see Cache.Mmap.
+// The file stays mapped until the Entry is closed.
+func (c *Cache) getMapped(ctx context.Context, hash uint64) (*Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%016x_0", hash)
+	f, err := c.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer close(f)
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, mapped, err := mapFile(f)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := Entry{
+		hash:     hash,
+		fsys:     c.fsys,
+		fileSize: int64(len(data)),
+		name0:    name,
+		name1:    name,
+		stat0:    stat,
+		data:     data,
+		mapped:   mapped,
+		limits:   c.limits(),
+	}
+	// the mapped file is read like the other entry files
+	if err = entry.read(ctx, memFile{Reader: bytes.NewReader(data), info: stat}); err != nil {
+		entry.Close()
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// BodyBytes returns the body of the entry, see Body.
+//
+// If the entry is read from a cache whose Mmap field is set, the body is not copied:
+// the returned slice is the mapped file, it must not be modified and it is valid
+// until the entry is closed.
+func (e *Entry) BodyBytes() ([]byte, error) {
+	if e.data != nil && !e.sparse {
+		return e.data[e.offset1 : e.offset1+e.dataSize1 : e.offset1+e.dataSize1], nil
+	}
+
+	body, err := e.Body()
+	if err != nil {
+		return nil, err
+	}
+	defer close(body)
+	return ioutil.ReadAll(body)
+}
+
+// bytesReader reads a stream of a mapped file.
+type bytesReader struct {
+	*bytes.Reader
+}
+
+func (bytesReader) Close() error {
+	return nil
+}
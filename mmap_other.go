@@ -0,0 +1,18 @@
+//go:build !linux
+
+package simplecache
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapFile is not supported, the files are read in memory.
+func mmapFile(f *os.File) ([]byte, error) {
+	return nil, errors.New("mmap not supported")
+}
+
+// munmapFile releases a file mapped by mmapFile.
+func munmapFile(data []byte) error {
+	return nil
+}
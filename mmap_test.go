@@ -0,0 +1,164 @@
+package simplecache_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestMmap(t *testing.T) {
+	cache, err := simplecache.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped, err := simplecache.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped.Mmap = true
+
+	urls, err := cache.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range urls {
+		want, err := cache.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := mapped.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wantHeader, err := want.Header()
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, err := entry.Header()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Get("Content-Length") != wantHeader.Get("Content-Length") {
+			t.Fatalf("%s: content-length: %s, want: %s", url,
+				header.Get("Content-Length"), wantHeader.Get("Content-Length"))
+		}
+
+		wantBody := readBody(t, cache, url)
+		body, err := entry.BodyBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, wantBody) {
+			t.Fatalf("%s: body differs", url)
+		}
+		if body = readBody(t, mapped, url); !bytes.Equal(body, wantBody) {
+			t.Fatalf("%s: body differs", url)
+		}
+
+		// the mapped file is released by Close, the entry file is then read by name
+		want.Close()
+		if err = entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if body, err = entry.BodyBytes(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, wantBody) {
+			t.Fatalf("%s: body differs after Close", url)
+		}
+	}
+
+	// corrupt entries are rejected as without Mmap
+	path := copyCache(t, "testdata")
+	defer os.RemoveAll(path)
+	url := "https://golang.org/doc/gopher/pkg.png"
+	corruptEntry(t, path, url, func(data []byte) []byte {
+		return data[:len(data)-1]
+	})
+	if mapped, err = simplecache.OpenCache(path); err != nil {
+		t.Fatal(err)
+	}
+	mapped.Mmap = true
+	if _, err = mapped.Get(url); err == nil {
+		t.Fatal("err is nil")
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	benchmarkGet(b, false)
+}
+
+func BenchmarkGetMmap(b *testing.B) {
+	benchmarkGet(b, true)
+}
+
+// benchmarkGet reads the header and the body of every entry of testdata.
+func benchmarkGet(b *testing.B, mmap bool) {
+	cache, err := simplecache.OpenCache("testdata")
+	if err != nil {
+		b.Fatal(err)
+	}
+	urls, err := cache.URLs()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		cache.Mmap = mmap
+		for _, url := range urls {
+			entry, err := cache.Get(url)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err = entry.Header(); err != nil {
+				b.Fatal(err)
+			}
+			body, err := entry.Body()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err = ioutil.ReadAll(body); err != nil {
+				b.Fatal(err)
+			}
+			body.Close()
+			entry.Close()
+		}
+	}
+}
+
+func BenchmarkBodyBytes(b *testing.B) {
+	benchmarkBodyBytes(b, false)
+}
+
+func BenchmarkBodyBytesMmap(b *testing.B) {
+	benchmarkBodyBytes(b, true)
+}
+
+// benchmarkBodyBytes reads the body of a large entry.
+func benchmarkBodyBytes(b *testing.B, mmap bool) {
+	cache, err := simplecache.OpenCache("testdata")
+	if err != nil {
+		b.Fatal(err)
+	}
+	cache.Mmap = mmap
+
+	entry, err := cache.Get("https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer entry.Close()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		body, err := entry.BodyBytes()
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(int64(len(body)))
+	}
+}
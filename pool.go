@@ -0,0 +1,54 @@
+package simplecache
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// workers returns the number of goroutines reading the entry files, see Cache.Workers.
+func (c *Cache) workers() int {
+	if c.Workers > 0 {
+		return c.Workers
+	}
+	return runtime.NumCPU()
+}
+
+// forEach calls fn for every i in [0, n) on at most workers goroutines.
+// No more call is started once ctx is done, and ctx.Err() is returned.
+func forEach(ctx context.Context, n, workers int, fn func(i int)) error {
+	if workers > n {
+		workers = n
+	}
+
+	var next int64 = -1
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= n {
+					return
+				}
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// readURLs reads the URLs of the entries of hashes on the worker pool.
+// urls[i] is the URL of hashes[i], it is empty if errs[i] is not nil.
+func (c *Cache) readURLs(ctx context.Context, hashes []uint64) (urls []string, errs []error, err error) {
+	urls = make([]string, len(hashes))
+	errs = make([]error, len(hashes))
+	err = forEach(ctx, len(hashes), c.workers(), func(i int) {
+		urls[i], errs[i] = c.readURL(hashes[i])
+	})
+	return urls, errs, err
+}
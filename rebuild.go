@@ -0,0 +1,139 @@
+package simplecache
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// entryFiles are the files of an entry: "hash_0", "hash_1" and "hash_s".
+type entryFiles struct {
+	hash    uint64
+	names   []string
+	size    int64
+	modTime time.Time
+}
+
+// parseEntryName returns the hash and the suffix of an entry file name, like "329f9c2d34eb0523_0".
+func parseEntryName(name string) (uint64, string, bool) {
+	if len(name) != 18 || name[16] != '_' {
+		return 0, "", false
+	}
+	suffix := name[17:]
+	if suffix != "0" && suffix != "1" && suffix != "s" {
+		return 0, "", false
+	}
+	hash, err := strconv.ParseUint(name[:16], 16, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return hash, suffix, true
+}
+
+// scanEntryFiles returns the entry files of the cache, sorted by hash.
+func scanEntryFiles(fsys fs.FS) ([]*entryFiles, error) {
+	dirEntries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*entryFiles
+	byHash := make(map[uint64]*entryFiles)
+
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.Type().IsRegular() {
+			continue
+		}
+		hash, _, ok := parseEntryName(dirEntry.Name())
+		if !ok {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		files, ok := byHash[hash]
+		if !ok {
+			files = &entryFiles{hash: hash}
+			byHash[hash] = files
+			entries = append(entries, files)
+		}
+		files.names = append(files.names, info.Name())
+		files.size += info.Size()
+		if info.ModTime().After(files.modTime) {
+			files.modTime = info.ModTime()
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].hash < entries[j].hash
+	})
+	return entries, nil
+}
+
+// RebuildIndex writes the file named "path/index-dir/the-real-index"
+// from the entry files named "path/hash_0", "path/hash_1" and "path/hash_s".
+//
+// The size of an entry is the size of its files. The last used time of an entry
+// is the response time read from its stream 0, or the latest modification time
+// of its files if the entry file "path/hash_0" can not be read.
+//
+// version is the version of the written index, from 6 to 9. A zero version keeps
+// the version of the current index, or the version of the fake index file named
+// "path/index" if the current index can not be read, or 6 if neither can be read.
+// The fake index file is written with the version of the index.
+func RebuildIndex(path string, version uint32) error {
+	cache := Cache{path: path, fsys: os.DirFS(path)}
+	if version == 0 {
+		version = indexVersion
+		if index, err := readIndex(cache.fsys); err == nil {
+			version = index.Header.Version
+		} else if fake, err := readFakeIndex(cache.fsys); err == nil {
+			version = fake.Version
+		}
+	}
+	if version < indexVersion || version > indexVersionLast {
+		return fmt.Errorf("rebuild index %s: version: %d, want: %d to %d",
+			path, version, indexVersion, indexVersionLast)
+	}
+
+	if err := writeFakeIndex(path, version); err != nil {
+		return fmt.Errorf("rebuild index %s: %v", path, err)
+	}
+
+	files, err := scanEntryFiles(cache.fsys)
+	if err != nil {
+		return fmt.Errorf("rebuild index %s: %v", path, err)
+	}
+
+	index := realIndex{
+		Header:   indexHeader{Version: version},
+		Entries:  make([]indexEntry, 0, len(files)),
+		Modified: chromeTime(time.Now()),
+	}
+
+	for _, f := range files {
+		lastUsed := chromeTime(f.modTime)
+		if entry, err := cache.getHash(f.hash); err == nil {
+			if t, err := entry.responseTime(); err == nil {
+				lastUsed = t
+			}
+			entry.Close()
+		}
+
+		index.Entries = append(index.Entries, indexEntry{
+			Hash:     f.hash,
+			LastUsed: lastUsed,
+			Size:     index.packSize(uint64(f.size), 0),
+		})
+	}
+
+	if err = writeIndex(path, &index); err != nil {
+		return fmt.Errorf("rebuild index %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package simplecache_test
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestRebuildIndex(t *testing.T) {
+	path := copyCache(t, "testdata")
+	defer os.RemoveAll(path)
+
+	want, err := simplecache.URLs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(path, "index-dir", "the-real-index")
+	for _, version := range []uint32{6, 7, 8, 9} {
+		if err = os.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+		if err = simplecache.RebuildIndex(path, version); err != nil {
+			t.Fatal(err)
+		}
+		testRealIndex(t, name, version, len(want))
+		testFakeIndex(t, path, version)
+
+		urls, err := simplecache.URLs(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(urls) != len(want) {
+			t.Fatalf("urls: %d, want: %d", len(urls), len(want))
+		}
+		for _, url := range urls {
+			testEntry(t, url, path)
+		}
+	}
+
+	// the version of the fake index is kept if the real index can not be read
+	if err = simplecache.Migrate(path, 8); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if err = simplecache.RebuildIndex(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	testRealIndex(t, name, 8, len(want))
+	testFakeIndex(t, path, 8)
+
+	if err = simplecache.RebuildIndex(path, 10); err == nil {
+		t.Fatal("err is nil")
+	}
+}
+
+func testRealIndex(t *testing.T, name string, version uint32, count int) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := binary.LittleEndian.Uint32(data[0:])
+	if int(payload) != len(data)-8 {
+		t.Fatalf("payload: %d, want: %d", payload, len(data)-8)
+	}
+	crc := binary.LittleEndian.Uint32(data[4:])
+	if actual := crc32.ChecksumIEEE(data[8:]); crc != actual {
+		t.Fatalf("crc: %x, want: %x", crc, actual)
+	}
+	if v := binary.LittleEndian.Uint32(data[16:]); v != version {
+		t.Fatalf("version: %d, want: %d", v, version)
+	}
+	if n := binary.LittleEndian.Uint64(data[20:]); int(n) != count {
+		t.Fatalf("entry count: %d, want: %d", n, count)
+	}
+}
+
+// copyCache copies the files of the cache directory src to a temporary directory.
+func copyCache(t *testing.T, src string) string {
+	dst, err := ioutil.TempDir("", "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = filepath.Walk(src, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if name != src && filepath.Dir(rel) == "." && rel != "index-dir" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(dst, rel), 0700)
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dst, rel), data, 0600)
+	})
+	if err != nil {
+		os.RemoveAll(dst)
+		t.Fatal(err)
+	}
+	return dst
+}
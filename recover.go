@@ -0,0 +1,230 @@
+package simplecache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+)
+
+// RecoveredEntry is an entry read by Cache.Recover from a possibly truncated entry file.
+type RecoveredEntry struct {
+	URL string
+	// Truncated is true if the entry file ends before the EOF record of stream 0.
+	// The body is then the intact part of stream 1, and the HTTP response info
+	// (stream 0) is missing if it was not entirely written.
+	Truncated bool
+	stream0   []byte
+	stream1   []byte
+}
+
+// Recover reads the entry for url from the start of the file named "path/hash(url)_0",
+// so that the intact streams of an entry file cut off while Chromium was writing it,
+// or while the cache was copied, are returned.
+//
+// Stream 1 is read until its EOF record, or until the end of the file if the record
+// is missing. Stream 0 is read after the EOF record of stream 1, its size is known
+// from the pickled HTTP response info. The CRC of a stream is verified if its EOF
+// record is present, an error is returned if the header or a CRC is not valid.
+func (c *Cache) Recover(url string) (*RecoveredEntry, error) {
+	hash := entryHash(url)
+	data, err := fs.ReadFile(c.fsys, fmt.Sprintf("%016x_0", hash))
+	if err != nil {
+		return nil, fmt.Errorf("recover %s: %v", url, err)
+	}
+
+	recovered, err := DecodeEntry(data)
+	if err != nil {
+		return nil, fmt.Errorf("recover %s: %v", url, err)
+	}
+	if recovered.URL != url {
+		return nil, fmt.Errorf("recover %s: key mismatch: %s", url, recovered.URL)
+	}
+	return recovered, nil
+}
+
+// DecodeEntry decodes an entry file "hash_0" from the start of data, as described in
+// Cache.Recover. data may be truncated, or followed by unrelated data like in a disk image.
+func DecodeEntry(data []byte) (*RecoveredEntry, error) {
+	if err := checkKeyLen(data); err != nil {
+		return nil, err
+	}
+
+	entry := Entry{limits: defaultLimits}
+	if err := entry.readHeader(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	recovered := RecoveredEntry{URL: entry.URL}
+	if err := recovered.read(data, entryHeaderSize+entry.keyLen); err != nil {
+		return nil, err
+	}
+	return &recovered, nil
+}
+
+// read reads the streams of the entry file data, stream 1 starting at offset start.
+func (e *RecoveredEntry) read(data []byte, start int64) error {
+	s := salvage{data: data}
+
+	offset1, eof1, ok := s.scanStream1(start)
+	if !ok {
+		e.stream1 = data[start:]
+		e.Truncated = true
+		return nil
+	}
+	e.stream1 = data[start:offset1]
+	if actual := crc32.ChecksumIEEE(e.stream1); eof1.HasCRC32() && eof1.CRC != actual {
+		return fmt.Errorf("stream1 CRC: %x, want: %x", eof1.CRC, actual)
+	}
+
+	// the pickled HTTP response info starts with its payload size
+	offset0 := offset1 + entryEOFSize
+	rest := data[offset0:]
+	if len(rest) < 4 {
+		e.Truncated = true
+		return nil
+	}
+	size0 := 4 + int64(binary.LittleEndian.Uint32(rest))
+	if size0 > int64(len(rest)) {
+		e.Truncated = true
+		return nil
+	}
+	e.stream0 = rest[:size0]
+
+	// the EOF record of stream 0 follows stream 0, or the SHA-256 of the key
+	for _, offset := range []int64{offset0 + size0, offset0 + size0 + sha256.Size} {
+		eof0, ok := s.readEOF(offset)
+		if !ok || int64(eof0.StreamSize) != size0 {
+			continue
+		}
+		if actual := crc32.ChecksumIEEE(e.stream0); eof0.HasCRC32() && eof0.CRC != actual {
+			return fmt.Errorf("stream0 CRC: %x, want: %x", eof0.CRC, actual)
+		}
+		return nil
+	}
+
+	e.Truncated = true
+	return nil
+}
+
+// Key returns the URL of the entry.
+func (e *RecoveredEntry) Key() string {
+	return e.URL
+}
+
+// Header returns the HTTP header,
+// an error is returned if the HTTP response info was not recovered.
+func (e *RecoveredEntry) Header() (http.Header, error) {
+	if e.stream0 == nil {
+		return nil, fmt.Errorf("read header: stream0 not recovered")
+	}
+	return parseHeader(e.stream0, defaultLimits)
+}
+
+// Response returns the HTTP response, its body is read as described in Body.
+// An error is returned if the HTTP response info was not recovered.
+func (e *RecoveredEntry) Response() (*http.Response, error) {
+	if e.stream0 == nil {
+		return nil, fmt.Errorf("read response: stream0 not recovered")
+	}
+	resp, err := parseResponse(e.stream0, defaultLimits)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body, _ = e.Body()
+	return resp, nil
+}
+
+// Body returns the HTTP body, which is incomplete if the entry is truncated.
+func (e *RecoveredEntry) Body() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(e.stream1)), nil
+}
+
+// Stream returns the stream index of the entry.
+// Stream 0 is the pickled HTTP response info and stream 1 is the body.
+// An error is returned for stream 0 if it was not recovered.
+func (e *RecoveredEntry) Stream(index int) (io.ReadCloser, error) {
+	switch index {
+	case 0:
+		if e.stream0 == nil {
+			return nil, fmt.Errorf("read stream0: not recovered")
+		}
+		return ioutil.NopCloser(bytes.NewReader(e.stream0)), nil
+	case 1:
+		return e.Body()
+	}
+	return nil, fmt.Errorf("stream %d: not supported", index)
+}
+
+// Close implements Record, the entry is held in memory.
+func (e *RecoveredEntry) Close() error {
+	return nil
+}
+
+// RecoveredSparse is a sparse file "hash_s" decoded by DecodeSparse.
+type RecoveredSparse struct {
+	URL    string
+	Ranges []SparseRange
+}
+
+// SparseRange is a range of the sparse data of an entry, see Cache.WriteSparse.
+type SparseRange struct {
+	Offset int64
+	Data   []byte
+}
+
+// DecodeSparse decodes a sparse file "hash_s" from the start of data.
+// The ranges are decoded until one is truncated, or its magic or its CRC does not match,
+// so that data may be truncated or followed by unrelated data like in a disk image.
+func DecodeSparse(data []byte) (*RecoveredSparse, error) {
+	if err := checkKeyLen(data); err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(data)
+	key, err := readSparseHeader(r, defaultLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	sparse := RecoveredSparse{URL: key}
+	for {
+		var rangeHeader sparseRangeHeader
+		if err = binary.Read(r, binary.LittleEndian, &rangeHeader); err != nil {
+			break
+		}
+		if rangeHeader.Magic != sparseMagicNumber ||
+			rangeHeader.Offset < 0 || rangeHeader.Len < 0 || rangeHeader.Len > int64(r.Len()) {
+			break
+		}
+
+		offset := int64(len(data) - r.Len())
+		stream := data[offset : offset+rangeHeader.Len]
+		if crc32.ChecksumIEEE(stream) != rangeHeader.CRC {
+			break
+		}
+		sparse.Ranges = append(sparse.Ranges, SparseRange{
+			Offset: rangeHeader.Offset,
+			Data:   stream,
+		})
+		r.Seek(rangeHeader.Len, io.SeekCurrent)
+	}
+	return &sparse, nil
+}
+
+// checkKeyLen verifies that the key of the EntryHeader starting data fits in data.
+func checkKeyLen(data []byte) error {
+	if int64(len(data)) < entryHeaderSize {
+		return fmt.Errorf("read header: %v", io.ErrUnexpectedEOF)
+	}
+	keyLen := int64(int32(binary.LittleEndian.Uint32(data[12:])))
+	if keyLen < 0 || keyLen > int64(len(data))-entryHeaderSize {
+		return fmt.Errorf("header key length: %d", keyLen)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package simplecache_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestRecover(t *testing.T) {
+	path := copyCache(t, "testdata")
+	defer os.RemoveAll(path)
+
+	cache, err := simplecache.OpenCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	urls, err := cache.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// v6 entries, then v9 entries with the SHA-256 of their key
+	for _, version := range []uint32{6, 9} {
+		if err = simplecache.Migrate(path, version); err != nil {
+			t.Fatal(err)
+		}
+		for _, url := range urls {
+			recovered := testRecover(t, cache, url, false)
+			if _, err = recovered.Response(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// the stream 0 EOF record is cut off
+	url := "https://golang.org/pkg/bufio/"
+	want := readBody(t, cache, url)
+	corruptEntry(t, path, url, func(data []byte) []byte {
+		return data[:len(data)-10]
+	})
+	recovered := testRecover(t, cache, url, true)
+	header, err := recovered.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(header) == 0 {
+		t.Fatal("header is empty")
+	}
+	if body := readRecoveredBody(t, recovered); !bytes.Equal(body, want) {
+		t.Fatalf("body: %d bytes, want: %d bytes", len(body), len(want))
+	}
+
+	// the truncated entry is stored without CRC
+	dstPath := filepath.Join(t.TempDir(), "Cache")
+	dst, err := simplecache.CreateCache(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dst.PutRecovered(recovered); err != nil {
+		t.Fatal(err)
+	}
+	if body := readBody(t, dst, url); !bytes.Equal(body, want) {
+		t.Fatalf("body: %d bytes, want: %d bytes", len(body), len(want))
+	}
+	names, err := filepath.Glob(filepath.Join(dstPath, "*_0"))
+	if err != nil || len(names) != 1 {
+		t.Fatalf("entry files: %v, %v", names, err)
+	}
+	data, err := ioutil.ReadFile(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flag := binary.LittleEndian.Uint32(data[len(data)-20+8:]); flag&1 != 0 {
+		t.Fatalf("stream0 flag: %x, want no CRC", flag)
+	}
+
+	// stream 1 is cut off
+	url = "https://golang.org/pkg/bytes/"
+	want = readBody(t, cache, url)
+	corruptEntry(t, path, url, func(data []byte) []byte {
+		return data[:len(url)+20+1000]
+	})
+	recovered = testRecover(t, cache, url, true)
+	if _, err = recovered.Response(); err == nil {
+		t.Fatal("err is nil")
+	}
+	if body := readRecoveredBody(t, recovered); !bytes.Equal(body, want[:1000]) {
+		t.Fatalf("body: %d bytes, want: %d bytes", len(body), 1000)
+	}
+	if err = dst.PutRecovered(recovered); err == nil {
+		t.Fatal("err is nil")
+	}
+
+	if _, err = cache.Recover("https://golang.org/unknown/"); err == nil {
+		t.Fatal("err is nil")
+	}
+}
+
+func testRecover(t *testing.T, cache *simplecache.Cache, url string, truncated bool) *simplecache.RecoveredEntry {
+	recovered, err := cache.Recover(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovered.URL != url {
+		t.Fatalf("url: %s, want: %s", recovered.URL, url)
+	}
+	if recovered.Truncated != truncated {
+		t.Fatalf("%s: truncated: %t, want: %t", url, recovered.Truncated, truncated)
+	}
+	return recovered
+}
+
+func readBody(t *testing.T, cache *simplecache.Cache, url string) []byte {
+	entry, err := cache.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	body, err := entry.Body()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func readRecoveredBody(t *testing.T, recovered *simplecache.RecoveredEntry) []byte {
+	body, err := recovered.Body()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,308 @@
+package simplecache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// RepairOptions controls how Cache.Repair salvages an entry.
+type RepairOptions struct {
+	// AcceptCRC keeps the data of a stream which can not be verified,
+	// because its CRC does not match or its EOF record is reconstructed,
+	// and recomputes its CRC. Otherwise such a stream is truncated.
+	AcceptCRC bool
+	// DryRun reports the changes without writing them.
+	DryRun bool
+}
+
+// RepairKind is the kind of change made by Cache.Repair to a part of an entry file.
+type RepairKind string
+
+const (
+	// RepairRewrite rewrites the header, or the SHA-256 of the key, to match the key.
+	RepairRewrite RepairKind = "rewritten"
+	// RepairTruncate truncates a stream which can not be verified to 0 bytes.
+	RepairTruncate RepairKind = "truncated"
+	// RepairRecomputeCRC keeps the data of a stream which can not be verified,
+	// and recomputes its CRC.
+	RepairRecomputeCRC RepairKind = "CRC recomputed"
+)
+
+// RepairAction is a change made by Cache.Repair to a part of an entry file.
+type RepairAction struct {
+	// Part is "header", "stream0" or "stream1".
+	Part string
+	Kind RepairKind
+	// Reason describes the damage found, like "CRC: 1a2b3c4d, want: 5e6f7a8b".
+	Reason string
+	// Size is the size of the stream kept by RepairRecomputeCRC.
+	Size int64
+}
+
+// String returns the action as printed by the simplecache command,
+// like "stream1: CRC: 1a2b3c4d, want: 5e6f7a8b, truncated to 0 bytes".
+func (a RepairAction) String() string {
+	switch a.Kind {
+	case RepairTruncate:
+		return fmt.Sprintf("%s: %s, truncated to 0 bytes", a.Part, a.Reason)
+	case RepairRecomputeCRC:
+		return fmt.Sprintf("%s: %s, CRC recomputed over %d bytes", a.Part, a.Reason, a.Size)
+	}
+	return fmt.Sprintf("%s: %s, %s", a.Part, a.Reason, a.Kind)
+}
+
+// RepairReport is the result of Cache.Repair for an entry.
+type RepairReport struct {
+	URL string
+	// Name is the hash of the entry, like "329f9c2d34eb0523".
+	Name string
+	// Actions lists each change, it is empty if the entry is valid.
+	Actions []RepairAction
+	// Written is true if the entry file was rewritten,
+	// it is false if the entry is valid or if opts.DryRun is true.
+	Written bool
+}
+
+// Repair salvages the entry file of key, and returns a report of the changes.
+// The actions of the report are empty if the entry is valid.
+//
+// The header of the file named "path/hash(key)_0" is rewritten if it does not match key.
+// The EOF record of a stream is reconstructed from the offset of the other stream,
+// or from the size of the pickled HTTP response info (stream 0).
+// A stream which can not be verified is truncated, unless opts.AcceptCRC is true.
+// An error is returned if no stream can be salvaged.
+//
+// The file is then re-encoded, and the size of the entry is updated in the file
+// named "path/index-dir/the-real-index". The file named "path/hash(key)_s" is not read.
+func (c *Cache) Repair(key string, opts RepairOptions) (RepairReport, error) {
+	hash := entryHash(key)
+	report := RepairReport{URL: key, Name: fmt.Sprintf("%016x", hash)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index, err := readIndex(c.fsys)
+	if err != nil {
+		return report, fmt.Errorf("repair %s: %v", key, err)
+	}
+
+	name := report.Name + "_0"
+	data, err := fs.ReadFile(c.fsys, name)
+	if err != nil {
+		return report, fmt.Errorf("repair %s: %v", key, err)
+	}
+
+	s := salvage{
+		key:       key,
+		data:      data,
+		acceptCRC: opts.AcceptCRC,
+		keySHA256: index.Header.Version >= keySHA256Version,
+	}
+	err = s.run()
+	report.Actions = s.actions
+	if err != nil {
+		return report, fmt.Errorf("repair %s: %v", key, err)
+	}
+	if len(report.Actions) == 0 || opts.DryRun {
+		return report, nil
+	}
+
+	if err = c.checkWritable(); err != nil {
+		return report, fmt.Errorf("repair %s: %v", key, err)
+	}
+	err = writeFile(filepath.Join(c.path, name), encodeEntry(key, s.stream0, s.stream1, s.keySHA256, true))
+	if err != nil {
+		return report, fmt.Errorf("repair %s: %v", key, err)
+	}
+
+	now := time.Now()
+	lastUsed := chromeTime(now)
+	for _, e := range index.Entries {
+		if e.Hash == hash {
+			lastUsed = e.LastUsed
+		}
+	}
+	index.put(indexEntry{
+		Hash:     hash,
+		LastUsed: lastUsed,
+		Size:     index.packSize(uint64(c.filesSize(hash)), 0),
+	})
+	index.Modified = chromeTime(now)
+
+	report.Written = true
+
+	if err = writeIndex(c.path, index); err != nil {
+		return report, fmt.Errorf("repair %s: %v", key, err)
+	}
+	return report, nil
+}
+
+// salvage holds the streams salvaged from the content of an entry file.
+type salvage struct {
+	key       string
+	data      []byte
+	acceptCRC bool
+
+	actions          []RepairAction
+	stream0, stream1 []byte
+	keySHA256        bool
+}
+
+func (s *salvage) report(part string, kind RepairKind, reason string, size int64) {
+	s.actions = append(s.actions, RepairAction{Part: part, Kind: kind, Reason: reason, Size: size})
+}
+
+func (s *salvage) run() error {
+	start, err := s.checkHeader()
+	if err != nil {
+		return err
+	}
+
+	var kept0, kept1 bool
+	if offset0, eof, ok := s.tailStream0(start); ok {
+		kept0 = s.keep(0, &s.stream0, s.data[offset0:][:eof.StreamSize], eof)
+
+		// the EOF record of stream 1 precedes stream 0
+		offset1 := offset0 - entryEOFSize
+		if eof, ok := s.readEOF(offset1); ok && int64(eof.StreamSize) == offset1-start {
+			kept1 = s.keep(1, &s.stream1, s.data[start:offset1], eof)
+		} else {
+			kept1 = s.keepUnverified(1, &s.stream1, s.data[start:offset1], "EOF record reconstructed")
+		}
+
+	} else if offset1, eof, ok := s.scanStream1(start); ok {
+		kept1 = s.keep(1, &s.stream1, s.data[start:offset1], eof)
+
+		// the pickled HTTP response info starts with its payload size
+		stream0 := s.data[offset1+entryEOFSize:]
+		var size int64
+		if len(stream0) >= 4 {
+			size = 4 + int64(binary.LittleEndian.Uint32(stream0))
+		}
+		if size > 0 && size <= int64(len(stream0)) {
+			kept0 = s.keepUnverified(0, &s.stream0, stream0[:size], "EOF record reconstructed")
+		} else {
+			s.report("stream0", RepairTruncate, "not found", 0)
+		}
+
+	} else {
+		s.report("stream1", RepairTruncate, "not found", 0)
+		s.report("stream0", RepairTruncate, "not found", 0)
+	}
+
+	if !kept0 && !kept1 {
+		return fmt.Errorf("no stream to salvage")
+	}
+	return nil
+}
+
+// checkHeader checks the header and the key of the entry, and returns the offset of stream 1.
+func (s *salvage) checkHeader() (int64, error) {
+	start := entryHeaderSize + int64(len(s.key))
+	if int64(len(s.data)) < start || string(s.data[entryHeaderSize:start]) != s.key {
+		return 0, fmt.Errorf("key not found")
+	}
+
+	var header entryHeader
+	binary.Read(bytes.NewReader(s.data), binary.LittleEndian, &header)
+
+	want := entryHeader{
+		Magic:   initialMagicNumber,
+		Version: entryVersion,
+		KeyLen:  int32(len(s.key)),
+		KeyHash: superFastHash([]byte(s.key)),
+	}
+	if header != want {
+		s.report("header", RepairRewrite, fmt.Sprintf("magic: %x, version: %d, key length: %d, key hash: %x",
+			header.Magic, header.Version, header.KeyLen, header.KeyHash), 0)
+	}
+	return start, nil
+}
+
+// tailStream0 locates stream 0 from the EOF record ending the entry file,
+// and returns its offset and its EOF record.
+func (s *salvage) tailStream0(start int64) (int64, entryEOF, bool) {
+	eof, ok := s.readEOF(int64(len(s.data)) - entryEOFSize)
+	if !ok || eof.StreamSize < 0 {
+		return 0, eof, false
+	}
+
+	offset0 := int64(len(s.data)) - entryEOFSize - int64(eof.StreamSize)
+	if eof.HasSHA256() {
+		offset0 -= sha256.Size
+	}
+	if offset0-entryEOFSize < start {
+		return 0, eof, false
+	}
+
+	if eof.HasSHA256() {
+		s.keySHA256 = true
+		sum := s.data[offset0+int64(eof.StreamSize):][:sha256.Size]
+		if actual := sha256.Sum256([]byte(s.key)); !bytes.Equal(sum, actual[:]) {
+			s.report("stream0", RepairRewrite, fmt.Sprintf("key SHA-256: %x", sum), 0)
+		}
+	} else {
+		s.keySHA256 = false
+	}
+	return offset0, eof, true
+}
+
+// scanStream1 looks for the EOF record of stream 1, and returns its offset.
+func (s *salvage) scanStream1(start int64) (int64, entryEOF, bool) {
+	var magic [8]byte
+	binary.LittleEndian.PutUint64(magic[:], finalMagicNumber)
+
+	for offset := start; ; offset++ {
+		i := bytes.Index(s.data[offset:], magic[:])
+		if i < 0 {
+			return 0, entryEOF{}, false
+		}
+		offset += int64(i)
+
+		eof, ok := s.readEOF(offset)
+		if ok && int64(eof.StreamSize) == offset-start {
+			return offset, eof, true
+		}
+	}
+}
+
+// readEOF reads the EOF record at offset, it returns false if its magic does not match.
+func (s *salvage) readEOF(offset int64) (entryEOF, bool) {
+	var eof entryEOF
+	if offset < 0 || offset+entryEOFSize > int64(len(s.data)) {
+		return eof, false
+	}
+	binary.Read(bytes.NewReader(s.data[offset:]), binary.LittleEndian, &eof)
+	return eof, eof.Magic == finalMagicNumber
+}
+
+// keep sets the stream to data if its CRC matches the EOF record,
+// it returns false if the stream is truncated.
+func (s *salvage) keep(index int, stream *[]byte, data []byte, eof entryEOF) bool {
+	if actual := crc32.ChecksumIEEE(data); eof.HasCRC32() && eof.CRC != actual {
+		return s.keepUnverified(index, stream, data, fmt.Sprintf("CRC: %x, want: %x", eof.CRC, actual))
+	}
+	*stream = data
+	return true
+}
+
+// keepUnverified sets the stream to data if unverified data are accepted,
+// it returns false if the stream is truncated. The reason why data can not
+// be verified is reported.
+func (s *salvage) keepUnverified(index int, stream *[]byte, data []byte, reason string) bool {
+	part := fmt.Sprintf("stream%d", index)
+	if !s.acceptCRC {
+		s.report(part, RepairTruncate, reason, 0)
+		*stream = nil
+		return false
+	}
+	s.report(part, RepairRecomputeCRC, reason, int64(len(data)))
+	*stream = data
+	return true
+}
@@ -0,0 +1,118 @@
+package simplecache_test
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestRepair(t *testing.T) {
+	path := copyCache(t, "testdata")
+	defer os.RemoveAll(path)
+
+	src, err := simplecache.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := simplecache.OpenCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// valid entry
+	url := "https://golang.org/pkg/"
+	testRepair(t, cache, url, simplecache.RepairOptions{})
+
+	// corrupt header
+	url = "https://golang.org/pkg/io/"
+	corruptEntry(t, path, url, func(data []byte) []byte {
+		data[0] ^= 0xff
+		return data
+	})
+	testRepair(t, cache, url, simplecache.RepairOptions{}, "header: rewritten")
+	testEntry(t, url, path)
+	testSameBody(t, src, cache, url)
+
+	// corrupt body
+	url = "https://golang.org/doc/gopher/pkg.png"
+	corruptEntry(t, path, url, func(data []byte) []byte {
+		data[len(data)/2] ^= 0xff
+		return data
+	})
+	testRepair(t, cache, url, simplecache.RepairOptions{DryRun: true}, "stream0: truncated")
+	if _, err = cache.Get(url); err == nil {
+		t.Fatal("dry-run: err is nil")
+	}
+	testRepair(t, cache, url, simplecache.RepairOptions{AcceptCRC: true}, "stream0: CRC recomputed")
+	testEntry(t, url, path)
+
+	// truncated stream 0 EOF record
+	url = "https://golang.org/pkg/bufio/"
+	corruptEntry(t, path, url, func(data []byte) []byte {
+		return data[:len(data)-10]
+	})
+	testRepair(t, cache, url, simplecache.RepairOptions{AcceptCRC: true}, "stream0: CRC recomputed")
+	testEntry(t, url, path)
+	testSameBody(t, src, cache, url)
+
+	// truncated stream 1
+	url = "https://golang.org/pkg/bytes/"
+	corruptEntry(t, path, url, func(data []byte) []byte {
+		return data[:len(data)/2]
+	})
+	report, err := cache.Repair(url, simplecache.RepairOptions{AcceptCRC: true})
+	if err == nil {
+		t.Fatal("err is nil")
+	}
+	if len(report.Actions) != 2 || report.Written {
+		t.Fatalf("%s: report: %+v, want: 2 actions, not written", url, report)
+	}
+
+	testRealIndex(t, filepath.Join(path, "index-dir", "the-real-index"), 6, 19)
+}
+
+// testRepair verifies that Repair reports the actions want, as "part: kind",
+// and that the entry file is written unless it is valid or opts.DryRun is true.
+func testRepair(t *testing.T, cache *simplecache.Cache, url string, opts simplecache.RepairOptions, want ...string) {
+	report, err := cache.Repair(url, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.URL != url {
+		t.Fatalf("url: %s, want: %s", report.URL, url)
+	}
+
+	var actions []string
+	for _, action := range report.Actions {
+		actions = append(actions, fmt.Sprintf("%s: %s", action.Part, action.Kind))
+	}
+	if fmt.Sprint(actions) != fmt.Sprint(want) {
+		t.Fatalf("%s: actions: %q, want: %q", url, actions, want)
+	}
+	if written := len(want) > 0 && !opts.DryRun; report.Written != written {
+		t.Fatalf("%s: written: %t, want: %t", url, report.Written, written)
+	}
+}
+
+// corruptEntry rewrites the entry file of url with corrupt.
+func corruptEntry(t *testing.T, path, url string, corrupt func([]byte) []byte) {
+	sum := sha1.Sum([]byte(url))
+	name := filepath.Join(path, fmt.Sprintf("%016x_0", binary.LittleEndian.Uint64(sum[:8])))
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(name, corrupt(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = simplecache.Get(url, path); err == nil {
+		t.Fatalf("%s: corrupt entry: err is nil", url)
+	}
+}
@@ -0,0 +1,200 @@
+package simplecache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"time"
+)
+
+// EntryStat is the key and the metadata of an entry, see Stats.
+type EntryStat struct {
+	EntryInfo
+	URL          string
+	BodySize     int64 // the size of the body, see Body
+	ResponseTime time.Time
+	ContentType  string
+}
+
+// sidecarVersion is the version of the sidecar file format,
+// a sidecar file of another version is ignored.
+const sidecarVersion = 2
+
+// sidecarFile is the content of a sidecar file, encoded with gob.
+type sidecarFile struct {
+	Version int
+	Entries map[uint64]sidecarEntry
+}
+
+// sidecarEntry is the metadata of an entry stored in a sidecar file,
+// along with the sizes and the modification times of its files.
+type sidecarEntry struct {
+	URL          string
+	BodySize     int64
+	ResponseTime time.Time
+	ContentType  string
+	File0        fileStamp // the file "hash_0"
+	FileS        fileStamp // the file "hash_s", zero if it does not exist
+	Err          string    // the error reading the entry, left out of Stats
+}
+
+// fileStamp identifies a version of a file, it is zero if the file does not exist.
+type fileStamp struct {
+	Size    int64
+	ModTime int64
+}
+
+// Stats returns the key and the metadata of each entry of the cache, in the order of
+// the-real-index file. The entry files are read by c.Workers goroutines. The metadata of
+// the entries which can not be verified is zero, and the entries whose key can not be read
+// are logged and left out, like with URLs.
+//
+// If c.Sidecar is set, the metadata is read from the sidecar file: only the entries whose
+// files changed in size or modification time since the sidecar file was written are read,
+// and the sidecar file is written again if an entry changed. The entries left out are kept
+// in the sidecar file too, so that their files are not read again while they are unchanged.
+// An error is returned if the format of the index files is unexpected,
+// if ctx is done before every entry is read, or if the sidecar file can not be written.
+func (c *Cache) Stats(ctx context.Context) ([]EntryStat, error) {
+	index, err := readIndex(c.fsys)
+	if err != nil {
+		return nil, fmt.Errorf("stats %s: %v", c.path, err)
+	}
+	sidecar := c.readSidecar()
+
+	entries := make([]sidecarEntry, len(index.Entries))
+	changed := make([]bool, len(index.Entries))
+	errs := make([]error, len(index.Entries))
+	err = forEach(ctx, len(index.Entries), c.workers(), func(i int) {
+		hash := index.Entries[i].Hash
+		entries[i], changed[i], errs[i] = c.statEntry(hash, sidecar.Entries)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("stats %s: %v", c.path, err)
+	}
+
+	stats := make([]EntryStat, 0, len(index.Entries))
+	update := make(map[uint64]sidecarEntry, len(index.Entries))
+	var dirty bool
+	for i, ie := range index.Entries {
+		se := entries[i]
+		if se.File0 != (fileStamp{}) {
+			update[ie.Hash] = se
+			dirty = dirty || changed[i]
+		}
+		if errs[i] != nil {
+			log.Printf("Unable to get url from %s: %v\n", c.path, errs[i])
+			continue
+		}
+		stats = append(stats, EntryStat{
+			EntryInfo: EntryInfo{
+				Hash:     ie.Hash,
+				Size:     index.entrySize(ie),
+				LastUsed: fromChromeTime(ie.LastUsed),
+			},
+			URL:          se.URL,
+			BodySize:     se.BodySize,
+			ResponseTime: se.ResponseTime,
+			ContentType:  se.ContentType,
+		})
+	}
+
+	if c.Sidecar != "" && (dirty || len(update) != len(sidecar.Entries)) {
+		if err = writeSidecar(c.Sidecar, update); err != nil {
+			return stats, fmt.Errorf("stats %s: %v", c.path, err)
+		}
+	}
+	return stats, nil
+}
+
+// statEntry returns the sidecar entry of hash, read from the entry files
+// if they changed since they were stored in sidecar.
+//
+// The sidecar entry of an entry whose key can not be read holds the error, returned
+// again until the entry files change. The sidecar entry is zero if "hash_0" does not exist.
+func (c *Cache) statEntry(hash uint64, sidecar map[uint64]sidecarEntry) (sidecarEntry, bool, error) {
+	name := fmt.Sprintf("%016x", hash)
+	file0, err := statFile(c.fsys, name+"_0")
+	if err != nil {
+		return sidecarEntry{}, false, fmt.Errorf("getting %s_0: %v", name, err)
+	}
+	// the body of a sparse entry is stored in "hash_s", whose stamp is zero if it does not exist
+	fileS, _ := statFile(c.fsys, name+"_s")
+
+	if se, ok := sidecar[hash]; ok && se.File0 == file0 && se.FileS == fileS {
+		if se.Err != "" {
+			return se, false, errors.New(se.Err)
+		}
+		return se, false, nil
+	}
+
+	entry, err := c.getHash(hash)
+	if err != nil {
+		// the key of an entry which can not be verified is still listed, like with URLs
+		url, uerr := c.readURL(hash)
+		if uerr != nil {
+			err = fmt.Errorf("getting %s_0: %v", name, err)
+			return sidecarEntry{File0: file0, FileS: fileS, Err: err.Error()}, true, err
+		}
+		return sidecarEntry{URL: url, File0: file0, FileS: fileS}, true, nil
+	}
+	defer entry.Close()
+	se := sidecarEntry{URL: entry.URL, File0: file0, FileS: fileS}
+
+	if se.BodySize, err = entry.bodySize(); err != nil {
+		err = fmt.Errorf("getting %s: %v", entry.URL, err)
+		return sidecarEntry{File0: file0, FileS: fileS, Err: err.Error()}, true, err
+	}
+	if t, err := entry.responseTime(); err == nil {
+		se.ResponseTime = fromChromeTime(t)
+	}
+	if header, err := entry.Header(); err == nil {
+		se.ContentType = header.Get("Content-Type")
+	}
+	return se, true, nil
+}
+
+// statFile returns the fileStamp of the file name.
+func statFile(fsys fs.FS, name string) (fileStamp, error) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{Size: info.Size(), ModTime: info.ModTime().UnixNano()}, nil
+}
+
+// readSidecar reads the sidecar file of the cache.
+// A missing or unreadable sidecar file is read as empty, it is written again by Stats.
+func (c *Cache) readSidecar() sidecarFile {
+	var sidecar sidecarFile
+	if c.Sidecar == "" {
+		return sidecar
+	}
+
+	data, err := ioutil.ReadFile(c.Sidecar)
+	if err == nil {
+		err = gob.NewDecoder(bytes.NewReader(data)).Decode(&sidecar)
+	}
+	if err != nil || sidecar.Version != sidecarVersion {
+		return sidecarFile{}
+	}
+	return sidecar
+}
+
+// writeSidecar writes the sidecar file name.
+func writeSidecar(name string, entries map[uint64]sidecarEntry) error {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(sidecarFile{Version: sidecarVersion, Entries: entries})
+	if err != nil {
+		return fmt.Errorf("encode sidecar: %v", err)
+	}
+	if err = writeFile(name, buf.Bytes()); err != nil {
+		return fmt.Errorf("write sidecar: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package simplecache_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestStats(t *testing.T) {
+	cache, err := simplecache.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := cache.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls, err := cache.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != len(urls) {
+		t.Fatalf("stats: %d, want: %d", len(stats), len(urls))
+	}
+
+	for i, stat := range stats {
+		if stat.URL != urls[i] {
+			t.Fatalf("url: %s, want: %s", stat.URL, urls[i])
+		}
+		if body := readBody(t, cache, stat.URL); stat.BodySize != int64(len(body)) {
+			t.Fatalf("%s: body size: %d, want: %d", stat.URL, stat.BodySize, len(body))
+		}
+		if stat.ResponseTime.IsZero() {
+			t.Fatalf("%s: response time is zero", stat.URL)
+		}
+		if stat.URL == "https://golang.org/doc/gopher/pkg.png" && stat.ContentType != "image/png" {
+			t.Fatalf("%s: content-type: %s, want: %s", stat.URL, stat.ContentType, "image/png")
+		}
+	}
+}
+
+func TestSidecar(t *testing.T) {
+	path := copyCache(t, "testdata")
+	defer os.RemoveAll(path)
+
+	cache, err := simplecache.OpenCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Sidecar = filepath.Join(t.TempDir(), "sidecar")
+
+	want, err := cache.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(cache.Sidecar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the sidecar is not written again if the cache is unchanged
+	testSidecarStats(t, cache, want)
+	if after, err := os.Stat(cache.Sidecar); err != nil {
+		t.Fatal(err)
+	} else if !after.ModTime().Equal(info.ModTime()) {
+		t.Fatal("sidecar written again")
+	}
+
+	// an entry file with the same size and modification time is not read again
+	url := "https://golang.org/doc/gopher/pkg.png"
+	name := filepath.Join(path, "bb9d1cda868d278c_0")
+	if info, err = os.Stat(name); err != nil {
+		t.Fatal(err)
+	}
+	corruptEntry(t, path, url, func(data []byte) []byte {
+		data[len(data)/2] ^= 0xff
+		return data
+	})
+	if err = os.Chtimes(name, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	testSidecarStats(t, cache, want)
+
+	// the changed entry files are read again
+	modTime := info.ModTime().Add(time.Second)
+	if err = os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	urls, err := cache.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != len(want) {
+		t.Fatalf("urls: %d, want: %d", len(urls), len(want))
+	}
+	stats, err := cache.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stat := range stats {
+		if stat.URL == url && stat.BodySize != 0 {
+			t.Fatalf("%s: corrupt entry: body size: %d, want: 0", url, stat.BodySize)
+		}
+	}
+
+	// an unreadable sidecar file is written again
+	if err = os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(cache.Sidecar, []byte("sidecar"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err = cache.Stats(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != len(want)-1 {
+		t.Fatalf("stats: %d, want: %d", len(stats), len(want)-1)
+	}
+	testSidecarStats(t, cache, stats)
+}
+
+func TestSidecarSparse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Cache")
+	cache, err := simplecache.CreateCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Sidecar = filepath.Join(t.TempDir(), "sidecar")
+
+	url := "https://example.com/video.mp4"
+	if err = cache.WriteSparse(url, 0, []byte("sparse")); err != nil {
+		t.Fatal(err)
+	}
+	testSidecarBodySize(t, cache, url, 6)
+
+	// a range appended to the file "hash_s" is read again
+	if err = cache.WriteSparse(url, 100, []byte("appended")); err != nil {
+		t.Fatal(err)
+	}
+	testSidecarBodySize(t, cache, url, 14)
+}
+
+func TestSidecarUnchanged(t *testing.T) {
+	path := copyCache(t, "testdata")
+	defer os.RemoveAll(path)
+
+	cache, err := simplecache.OpenCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Sidecar = filepath.Join(t.TempDir(), "sidecar")
+
+	// an entry whose key can not be read
+	corruptEntry(t, path, "https://golang.org/doc/gopher/pkg.png", func(data []byte) []byte {
+		data[0] ^= 0xff
+		return data
+	})
+	// a sparse entry which can not be verified
+	url := "https://example.com/video.mp4"
+	if err = cache.WriteSparse(url, 0, []byte("sparse")); err != nil {
+		t.Fatal(err)
+	}
+	corruptEntry(t, path, url, func(data []byte) []byte {
+		data[len(data)-21] ^= 0xff
+		return data
+	})
+
+	want, err := cache.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 19 {
+		t.Fatalf("stats: %d, want: %d", len(want), 19)
+	}
+
+	// the sidecar file is not written again while the entries are unchanged
+	modTime := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(cache.Sidecar, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	testSidecarStats(t, cache, want)
+	if info, err := os.Stat(cache.Sidecar); err != nil {
+		t.Fatal(err)
+	} else if !info.ModTime().Equal(modTime) {
+		t.Fatal("sidecar written again")
+	}
+}
+
+// testSidecarBodySize verifies that the body size of the entry for url is want.
+func testSidecarBodySize(t *testing.T, cache *simplecache.Cache, url string, want int64) {
+	stats, err := cache.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].URL != url {
+		t.Fatalf("stats: %v, want: %s", stats, url)
+	}
+	if stats[0].BodySize != want {
+		t.Fatalf("%s: body size: %d, want: %d", url, stats[0].BodySize, want)
+	}
+}
+
+// testSidecarStats verifies that the stats of cache are want.
+func testSidecarStats(t *testing.T, cache *simplecache.Cache, want []simplecache.EntryStat) {
+	stats, err := cache.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("stats: %d, want: %d", len(stats), len(want))
+	}
+	for i := range stats {
+		if fmt.Sprint(stats[i]) != fmt.Sprint(want[i]) {
+			t.Fatalf("stat: %v, want: %v", stats[i], want[i])
+		}
+	}
+}
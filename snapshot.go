@@ -0,0 +1,100 @@
+package simplecache
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// OpenSnapshot copies the simple cache at path to a temporary directory, and opens the copy,
+// so that a cache in use by a running browser is read as it was when it was copied.
+//
+// The index files are copied first, then the entry files. A file modified while it is copied
+// is copied again, and the entry files deleted meanwhile are left out. The whole cache is
+// copied again if the real index is modified before the entry files are copied.
+//
+// The copy is read-only, and Close removes the temporary directory.
+// An error is returned if the cache keeps changing while it is copied.
+func OpenSnapshot(path string) (*Cache, error) {
+	src := os.DirFS(path)
+	if err := checkFakeIndex(src); err != nil {
+		return nil, fmt.Errorf("snapshot %s: %v", path, err)
+	}
+
+	var err error
+	for i := 0; i < openRetries; i++ {
+		var dir string
+		if dir, err = snapshot(src); err == nil {
+			return &Cache{path: dir, fsys: os.DirFS(dir), readOnly: true, snapshot: true}, nil
+		}
+		if dir != "" {
+			os.RemoveAll(dir)
+		}
+		if err != errEntryChanged {
+			break
+		}
+	}
+	return nil, fmt.Errorf("snapshot %s: %v", path, err)
+}
+
+// snapshot copies the index files and the entry files of src to a temporary directory.
+// errEntryChanged is returned if the real index is modified meanwhile.
+func snapshot(src fs.FS) (string, error) {
+	dir, err := ioutil.TempDir("", "simplecache-snapshot")
+	if err != nil {
+		return "", err
+	}
+	if err = os.Mkdir(filepath.Join(dir, "index-dir"), 0700); err != nil {
+		return dir, err
+	}
+
+	if err = copyStable(src, "index", dir); err != nil {
+		return dir, err
+	}
+	index, err := fs.Stat(src, "index-dir/the-real-index")
+	if err != nil {
+		return dir, err
+	}
+	if err = copyStable(src, "index-dir/the-real-index", dir); err != nil {
+		return dir, err
+	}
+
+	dirEntries, err := fs.ReadDir(src, ".")
+	if err != nil {
+		return dir, err
+	}
+	for _, dirEntry := range dirEntries {
+		if _, _, ok := parseEntryName(dirEntry.Name()); !ok || !dirEntry.Type().IsRegular() {
+			continue
+		}
+		err = copyStable(src, dirEntry.Name(), dir)
+		if err != nil && !os.IsNotExist(err) {
+			return dir, err
+		}
+	}
+
+	if !sameFile(src, "index-dir/the-real-index", index) {
+		return dir, errEntryChanged
+	}
+	return dir, nil
+}
+
+// copyStable copies the file name of src to the directory dst,
+// and copies it again if it is modified meanwhile.
+func copyStable(src fs.FS, name, dst string) error {
+	for i := 0; i < openRetries; i++ {
+		before, err := fs.Stat(src, name)
+		if err != nil {
+			return err
+		}
+		if _, err = copyFile(src, name, filepath.Join(dst, filepath.FromSlash(name))); err != nil {
+			return err
+		}
+		if sameFile(src, name, before) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %v", name, errEntryChanged)
+}
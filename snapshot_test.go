@@ -0,0 +1,191 @@
+package simplecache_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestEntryClose(t *testing.T) {
+	path := copyCache(t, "testdata")
+	defer os.RemoveAll(path)
+
+	cache, err := simplecache.OpenCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "https://golang.org/doc/gopher/pkg.png"
+	name := filepath.Join(path, "bb9d1cda868d278c_0")
+	want := readBody(t, cache, url)
+
+	// the entry file is replaced, then deleted
+	entry, err := cache.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(filepath.Join(path, "329f9c2d34eb0523_0"), name); err != nil {
+		t.Fatal(err)
+	}
+	testEntryBody(t, entry, want)
+	if err = os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	testEntryBody(t, entry, want)
+
+	// the entry file is opened again by name once closed
+	if err = entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = entry.Body(); err == nil {
+		t.Fatal("err is nil")
+	}
+	if err = entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEntryChanged(t *testing.T) {
+	path := copyCache(t, "testdata")
+	defer os.RemoveAll(path)
+
+	cache, err := simplecache.OpenCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "https://golang.org/doc/gopher/pkg.png"
+	entry, err := cache.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+
+	// the entry file is modified in place
+	if err = os.Truncate(filepath.Join(path, "bb9d1cda868d278c_0"), 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = entry.Header(); err == nil || !strings.Contains(err.Error(), "entry file changed") {
+		t.Fatalf("err: %v, want: entry file changed", err)
+	}
+	if _, err = entry.Body(); err == nil || !strings.Contains(err.Error(), "entry file changed") {
+		t.Fatalf("err: %v, want: entry file changed", err)
+	}
+
+	// the entry file is modified once the entry is closed
+	url = "https://golang.org/pkg/bufio/"
+	if entry, err = cache.Get(url); err != nil {
+		t.Fatal(err)
+	}
+	if err = entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	corruptEntry(t, path, url, func(data []byte) []byte {
+		return append(data, 0)
+	})
+	if _, err = entry.Body(); err == nil || !strings.Contains(err.Error(), "entry file changed") {
+		t.Fatalf("err: %v, want: entry file changed", err)
+	}
+}
+
+func TestEntryCloseSparse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Cache")
+	cache, err := simplecache.CreateCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "https://example.com/video.mp4"
+	if err = cache.WriteSparse(url, 0, []byte("sparse")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the ranges appended once the entry is read are not read
+	entry, err := cache.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	if err = cache.WriteSparse(url, 10, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	testEntryBody(t, entry, []byte("sparse"))
+
+	if err = entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testEntryBody(t, entry, []byte("sparsedata"))
+}
+
+func TestOpenSnapshot(t *testing.T) {
+	path := copyCache(t, "testdata")
+	defer os.RemoveAll(path)
+
+	cache, err := simplecache.OpenSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := simplecache.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the snapshot is not modified with the cache
+	url := "https://golang.org/doc/gopher/pkg.png"
+	if err = os.Remove(filepath.Join(path, "bb9d1cda868d278c_0")); err != nil {
+		t.Fatal(err)
+	}
+	urls, err := cache.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 19 {
+		t.Fatalf("urls: %d, want: %d", len(urls), 19)
+	}
+	if body := readBody(t, cache, url); !bytes.Equal(body, readBody(t, want, url)) {
+		t.Fatalf("%s: body differs", url)
+	}
+
+	if err = cache.Remove(url); err == nil {
+		t.Fatal("remove: err is nil")
+	}
+
+	// the copy is removed by Close
+	files, err := filepath.Glob(filepath.Join(os.TempDir(), "simplecache-snapshot*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := filepath.Glob(filepath.Join(os.TempDir(), "simplecache-snapshot*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(files)-1 {
+		t.Fatalf("snapshot directories: %d, want: %d", len(after), len(files)-1)
+	}
+
+	if _, err = simplecache.OpenSnapshot(filepath.Join(path, "index-dir")); err == nil {
+		t.Fatal("err is nil")
+	}
+}
+
+// testEntryBody verifies that the body of entry is want.
+func testEntryBody(t *testing.T, entry *simplecache.Entry, want []byte) {
+	body, err := entry.Body()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("%s: body: %d bytes, want: %d bytes", entry.URL, len(data), len(want))
+	}
+}
@@ -1,48 +1,79 @@
 package simplecache
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"os"
-	"path/filepath"
 	"sort"
 )
 
-func newSparseReader(hash uint64, path string) (io.ReadCloser, error) {
-	name := filepath.Join(path, fmt.Sprintf("%016x_s", hash))
-	file, err := os.Open(name)
+// sparseChunkSize is the size of the reads of a range, ctx is checked between them.
+const sparseChunkSize = 64 << 10
+
+// newSparseReader returns a reader of the ranges of the sparse file, which is closed with the reader.
+// Reading a range returns ctx.Err() once ctx is done.
+func newSparseReader(ctx context.Context, file file, limits Limits) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	key, err := readSparseHeader(file, limits)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	offset := entryHeaderSize + int64(len(key))
+	ranges, err := scan(ctx, file, offset, limits)
 	if err != nil {
-		return nil, fmt.Errorf("open sparse-file: %v", err)
+		file.Close()
+		return nil, fmt.Errorf("scan sparse-file: %v", err)
 	}
 
+	return &sparseReader{
+		ctx:    ctx,
+		file:   file,
+		ranges: ranges,
+	}, nil
+}
+
+// readSparseHeader reads the EntryHeader of a sparse file and returns its key.
+// The version of a sparse file is the version of the index.
+func readSparseHeader(file io.Reader, limits Limits) (string, error) {
 	var header entryHeader
-	err = binary.Read(file, binary.LittleEndian, &header)
+	err := binary.Read(file, binary.LittleEndian, &header)
 	if err != nil {
-		return nil, fmt.Errorf("read sparse-file header: %v", err)
+		return "", fmt.Errorf("read sparse-file header: %v", err)
 	}
 
 	if header.Magic != initialMagicNumber {
-		return nil, fmt.Errorf("sparse-file magic: %x, want: %x",
+		return "", fmt.Errorf("sparse-file magic: %x, want: %x",
 			header.Magic, initialMagicNumber)
 	}
-	// entryVersion ??
 	if header.Version < indexVersion {
-		return nil, fmt.Errorf("sparse-file version: %d, want: %d",
+		return "", fmt.Errorf("sparse-file version: %d, want: %d",
 			header.Version, indexVersion)
 	}
 
-	offset := entryHeaderSize + int64(header.KeyLen)
-	ranges, err := scan(file, offset)
+	err = checkSize("sparse-file key length", int64(header.KeyLen), limits.MaxKeySize)
 	if err != nil {
-		return nil, fmt.Errorf("scan sparse-file: %v", err)
+		return "", err
 	}
 
-	return &sparseReader{
-		file:   file,
-		ranges: ranges,
-	}, nil
+	key := make([]byte, header.KeyLen)
+	if _, err = io.ReadFull(file, key); err != nil {
+		return "", fmt.Errorf("read sparse-file key: %v", err)
+	}
+
+	sfh := superFastHash(key)
+	if header.KeyHash != sfh {
+		return "", fmt.Errorf("sparse-file key hash: %x, want: %x",
+			header.KeyHash, sfh)
+	}
+	return string(key), nil
 }
 
 // sparseReader reads sparse files.
@@ -53,18 +84,28 @@ func newSparseReader(hash uint64, path string) (io.ReadCloser, error) {
 //		- a SparseRangeHeader
 //		- a SparseRange
 type sparseReader struct {
-	file   *os.File
+	ctx    context.Context
+	file   file
 	ranges sparseRanges
 	index  int
 	stream []byte
 	r, w   int64
 }
 
-func scan(file io.ReadSeeker, offset int64) (sparseRanges, error) {
+// scan returns the ranges of a sparse file starting at offset,
+// it returns ctx.Err() once ctx is done.
+func scan(ctx context.Context, file io.ReadSeeker, offset int64, limits Limits) (sparseRanges, error) {
 	var ranges sparseRanges
-	var err error
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("scan sparse-range: %v", err)
+	}
 
 	for {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
 		_, err = file.Seek(offset, io.SeekStart)
 		if err != nil {
 			break
@@ -82,6 +123,16 @@ func scan(file io.ReadSeeker, offset int64) (sparseRanges, error) {
 			break
 		}
 
+		if rangeHeader.Offset < 0 {
+			err = fmt.Errorf("sparse-range offset: %d", rangeHeader.Offset)
+			break
+		}
+		err = checkSize("sparse-range size", rangeHeader.Len,
+			min64(limits.MaxStreamSize, size-offset-sparseRangeHeaderSize))
+		if err != nil {
+			break
+		}
+
 		rng := sparseRange{
 			Offset:     rangeHeader.Offset,
 			Len:        rangeHeader.Len,
@@ -130,9 +181,14 @@ func (sr *sparseReader) fill() error {
 	rng := sr.ranges[sr.index]
 	sr.stream = make([]byte, rng.Len)
 
-	_, err := sr.file.ReadAt(sr.stream, rng.FileOffset)
-	if err != nil {
-		return fmt.Errorf("read sparse-range: %v", err)
+	for offset := int64(0); offset < rng.Len; offset += sparseChunkSize {
+		if err := sr.ctx.Err(); err != nil {
+			return err
+		}
+		chunk := sr.stream[offset:min64(offset+sparseChunkSize, rng.Len)]
+		if _, err := sr.file.ReadAt(chunk, rng.FileOffset+offset); err != nil {
+			return fmt.Errorf("read sparse-range: %v", err)
+		}
 	}
 
 	actualCRC := crc32.ChecksumIEEE(sr.stream)
@@ -146,3 +202,132 @@ func (sr *sparseReader) fill() error {
 
 	return nil
 }
+
+// writeSparseRanges writes data at offset of the sparse stream stored in the file name,
+// which is created with an EntryHeader for key if it does not exist.
+//
+// The parts of data overlapping existing ranges are written in place and their CRC
+// updated, the other parts are appended as new ranges.
+func writeSparseRanges(name, key string, version uint32, offset int64, data []byte, limits Limits) error {
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("open sparse-file: %v", err)
+	}
+	defer close(file)
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat sparse-file: %v", err)
+	}
+	if stat.Size() == 0 {
+		err = binary.Write(file, binary.LittleEndian, entryHeader{
+			Magic:   initialMagicNumber,
+			Version: version,
+			KeyLen:  int32(len(key)),
+			KeyHash: superFastHash([]byte(key)),
+		})
+		if err == nil {
+			_, err = file.WriteString(key)
+		}
+		if err != nil {
+			return fmt.Errorf("write sparse-file header: %v", err)
+		}
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek sparse-file header: %v", err)
+	}
+	sparseKey, err := readSparseHeader(file, limits)
+	if err != nil {
+		return err
+	}
+	if sparseKey != key {
+		return fmt.Errorf("sparse-file key: %s, want: %s", sparseKey, key)
+	}
+
+	ranges, err := scan(context.Background(), file, entryHeaderSize+int64(len(key)), limits)
+	if err != nil {
+		return fmt.Errorf("scan sparse-file: %v", err)
+	}
+
+	end := offset + int64(len(data))
+	next := offset
+	var gaps []sparseRange
+
+	for _, rng := range ranges {
+		start, stop := max64(offset, rng.Offset), min64(end, rng.Offset+rng.Len)
+		if start >= stop {
+			continue
+		}
+		if start > next {
+			gaps = append(gaps, sparseRange{Offset: next, Len: start - next})
+		}
+		if err = overwriteRange(file, rng, start, data[start-offset:stop-offset]); err != nil {
+			return err
+		}
+		next = stop
+	}
+	if end > next {
+		gaps = append(gaps, sparseRange{Offset: next, Len: end - next})
+	}
+
+	for _, gap := range gaps {
+		if err = appendRange(file, gap.Offset, data[gap.Offset-offset:][:gap.Len]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// overwriteRange writes data at offset of the stream in the range rng, and updates its CRC.
+func overwriteRange(file *os.File, rng sparseRange, offset int64, data []byte) error {
+	stream := make([]byte, rng.Len)
+	_, err := file.ReadAt(stream, rng.FileOffset)
+	if err != nil {
+		return fmt.Errorf("read sparse-range: %v", err)
+	}
+	copy(stream[offset-rng.Offset:], data)
+
+	if _, err = file.Seek(rng.FileOffset-sparseRangeHeaderSize, io.SeekStart); err != nil {
+		return fmt.Errorf("seek sparse-range: %v", err)
+	}
+	return writeRange(file, rng.Offset, stream)
+}
+
+// appendRange appends a range of the stream at offset to the sparse file.
+func appendRange(file *os.File, offset int64, data []byte) error {
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("seek sparse-file end: %v", err)
+	}
+	return writeRange(file, offset, data)
+}
+
+func writeRange(file io.Writer, offset int64, data []byte) error {
+	err := binary.Write(file, binary.LittleEndian, sparseRangeHeader{
+		Magic:  sparseMagicNumber,
+		Offset: offset,
+		Len:    int64(len(data)),
+		CRC:    crc32.ChecksumIEEE(data),
+	})
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	if err != nil {
+		return fmt.Errorf("write sparse-range: %v", err)
+	}
+	return nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,119 @@
+package simplecache_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestWriteSparse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "Cache")
+	cache, err := simplecache.CreateCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := "https://example.com/video.webm"
+	writes := []struct {
+		offset int64
+		data   string
+		want   string
+	}{
+		{5, "world", "world"},
+		{0, "hello", "helloworld"},
+		{3, "LOWOR", "helLOWORld"},
+		{8, "LD!!", "helLOWORLD!!"},
+		{20, "", "helLOWORLD!!"},
+	}
+	for _, w := range writes {
+		if err = cache.WriteSparse(url, w.offset, []byte(w.data)); err != nil {
+			t.Fatal(err)
+		}
+		testSparseBody(t, cache, url, w.want)
+	}
+
+	entry, err := cache.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := entry.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(header) != 0 {
+		t.Fatalf("header: %v, want: empty", header)
+	}
+	testRealIndex(t, filepath.Join(path, "index-dir", "the-real-index"), 6, 1)
+
+	// the response of an existing entry is kept
+	url = "https://example.com/audio.ogg"
+	err = cache.Put(url, &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"audio/ogg"}},
+		Body:       ioutil.NopCloser(strings.NewReader("body")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cache.WriteSparse(url, 0, []byte("range")); err != nil {
+		t.Fatal(err)
+	}
+	testSparseBody(t, cache, url, "range")
+
+	entry, err = cache.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err = entry.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctype := header.Get("Content-Type"); ctype != "audio/ogg" {
+		t.Fatalf("content-type: %s, want: %s", ctype, "audio/ogg")
+	}
+	testRealIndex(t, filepath.Join(path, "index-dir", "the-real-index"), 6, 2)
+
+	if err = cache.WriteSparse(url, -1, []byte("range")); err == nil {
+		t.Fatal("err is nil")
+	}
+
+	// the sparse files follow the index version
+	if err = simplecache.Migrate(path, 9); err != nil {
+		t.Fatal(err)
+	}
+	if err = cache.WriteSparse(url, 5, []byte("s")); err != nil {
+		t.Fatal(err)
+	}
+	testSparseBody(t, cache, url, "ranges")
+}
+
+func testSparseBody(t *testing.T, cache *simplecache.Cache, url, want string) {
+	entry, err := cache.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := entry.Body()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Fatalf("body: %q, want: %q", data, want)
+	}
+}
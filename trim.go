@@ -0,0 +1,166 @@
+package simplecache
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"time"
+)
+
+// TrimOptions selects the entries removed by Cache.Trim.
+// The zero value of each field disables the matching criteria.
+type TrimOptions struct {
+	// MaxSize removes the least recently used entries
+	// until the cache size is at most MaxSize bytes.
+	MaxSize uint64
+	// Before removes the entries last used before Before.
+	Before time.Time
+	// Host removes the entries whose URL host matches the pattern Host,
+	// with the syntax of path.Match, like "*.example.com".
+	Host string
+	// DryRun reports the entries to remove without removing them.
+	DryRun bool
+}
+
+// TrimmedEntry is an entry removed by Cache.Trim.
+// URL is empty if the entry file could not be read.
+type TrimmedEntry struct {
+	URL      string
+	Size     uint64
+	LastUsed time.Time
+}
+
+// Trim removes entries from the cache as selected by opts,
+// and returns them in least recently used order.
+//
+// Entries are read from the file named "path/index-dir/the-real-index",
+// which is updated once all the entries files are deleted.
+func (c *Cache) Trim(opts TrimOptions) ([]TrimmedEntry, error) {
+	var matchHost func(url string) bool
+	if opts.Host != "" {
+		var err error
+		if matchHost, err = HostFilter(opts.Host); err != nil {
+			return nil, fmt.Errorf("trim %s: host: %v", c.path, err)
+		}
+	}
+	if !opts.DryRun {
+		if err := c.checkWritable(); err != nil {
+			return nil, fmt.Errorf("trim %s: %v", c.path, err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index, err := readIndex(c.fsys)
+	if err != nil {
+		return nil, fmt.Errorf("trim %s: %v", c.path, err)
+	}
+
+	lru := make([]indexEntry, len(index.Entries))
+	copy(lru, index.Entries)
+	sortLRU(lru)
+
+	var size uint64
+	for _, entry := range lru {
+		size += index.entrySize(entry)
+	}
+
+	hashes := make([]uint64, len(lru))
+	for i, entry := range lru {
+		hashes[i] = entry.Hash
+	}
+	keys, errs, err := c.readURLs(context.Background(), hashes)
+	if err != nil {
+		return nil, fmt.Errorf("trim %s: %v", c.path, err)
+	}
+
+	remove := make(map[uint64]bool)
+	urls := make(map[uint64]string)
+
+	for i, entry := range lru {
+		if errs[i] == nil {
+			urls[entry.Hash] = keys[i]
+		}
+
+		if !opts.Before.IsZero() && fromChromeTime(entry.LastUsed).Before(opts.Before) {
+			remove[entry.Hash] = true
+		} else if matchHost != nil && matchHost(urls[entry.Hash]) {
+			remove[entry.Hash] = true
+		}
+		if remove[entry.Hash] {
+			size -= index.entrySize(entry)
+		}
+	}
+
+	if opts.MaxSize > 0 {
+		for _, entry := range lru {
+			if size <= opts.MaxSize {
+				break
+			}
+			if !remove[entry.Hash] {
+				remove[entry.Hash] = true
+				size -= index.entrySize(entry)
+			}
+		}
+	}
+
+	var trimmed []TrimmedEntry
+	for _, entry := range lru {
+		if !remove[entry.Hash] {
+			continue
+		}
+		trimmed = append(trimmed, TrimmedEntry{
+			URL:      urls[entry.Hash],
+			Size:     index.entrySize(entry),
+			LastUsed: fromChromeTime(entry.LastUsed),
+		})
+
+		if opts.DryRun {
+			continue
+		}
+		if _, err = c.removeFiles(entry.Hash, "_0", "_1", "_s"); err != nil {
+			return nil, fmt.Errorf("trim %s: %v", c.path, err)
+		}
+		index.remove(entry.Hash)
+	}
+
+	if opts.DryRun || len(trimmed) == 0 {
+		return trimmed, nil
+	}
+
+	index.Modified = chromeTime(time.Now())
+	if err = writeIndex(c.path, index); err != nil {
+		return nil, fmt.Errorf("trim %s: %v", c.path, err)
+	}
+	return trimmed, nil
+}
+
+// sortLRU sorts the entries in least recently used order.
+func sortLRU(entries []indexEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].LastUsed == entries[j].LastUsed {
+			return entries[i].Hash < entries[j].Hash
+		}
+		return entries[i].LastUsed < entries[j].LastUsed
+	})
+}
+
+// HostFilter returns a filter selecting the URLs whose host matches pattern,
+// with the syntax of path.Match, like "*.example.com".
+// An error is returned if pattern is malformed.
+func HostFilter(pattern string) (func(url string) bool, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return func(rawurl string) bool {
+		u, err := url.Parse(rawurl)
+		if err != nil || u.Hostname() == "" {
+			return false
+		}
+		matched, _ := path.Match(pattern, u.Hostname())
+		return matched
+	}, nil
+}
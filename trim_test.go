@@ -0,0 +1,79 @@
+package simplecache_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestTrim(t *testing.T) {
+	path := copyCache(t, "testdata")
+	defer os.RemoveAll(path)
+
+	cache, err := simplecache.OpenCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trimmed, err := cache.Trim(simplecache.TrimOptions{MaxSize: 1300000, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"https://golang.org/favicon.ico",
+		"https://golang.org/pkg/builtin/",
+		"https://golang.org/pkg/bufio/",
+		"https://golang.org/pkg/bytes/",
+	}
+	testTrimmed(t, trimmed, want)
+
+	urls, err := cache.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 19 {
+		t.Fatalf("dry-run urls: %d, want: %d", len(urls), 19)
+	}
+
+	trimmed, err = cache.Trim(simplecache.TrimOptions{
+		Host:   "*.google-analytics.com",
+		Before: time.Date(2016, 7, 17, 18, 30, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"https://golang.org/favicon.ico",
+		"https://ssl.google-analytics.com/ga.js",
+	}
+	testTrimmed(t, trimmed, want)
+
+	for _, url := range want {
+		if _, err = cache.Get(url); err == nil {
+			t.Fatalf("%s: err is nil", url)
+		}
+	}
+	name := filepath.Join(path, "index-dir", "the-real-index")
+	testRealIndex(t, name, 6, 17)
+
+	if _, err = cache.Trim(simplecache.TrimOptions{Host: "["}); err == nil {
+		t.Fatal("err is nil")
+	}
+}
+
+func testTrimmed(t *testing.T, trimmed []simplecache.TrimmedEntry, want []string) {
+	if len(trimmed) != len(want) {
+		t.Fatalf("trimmed: %d, want: %d", len(trimmed), len(want))
+	}
+	for i := range want {
+		if trimmed[i].URL != want[i] {
+			t.Fatalf("trimmed: %s, want: %s", trimmed[i].URL, want[i])
+		}
+		if trimmed[i].Size == 0 {
+			t.Fatalf("trimmed %s: size is 0", trimmed[i].URL)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package simplecache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FS returns the entries of the cache as a read-only file system, where the body of the
+// entry for "https://example.com/path/file.js" is the file "https/example.com/path/file.js".
+//
+// The query of an URL is kept in the file name, like "file.js?v=1", and an URL whose
+// path ends with a slash is the file "index.html" of its directory. An URL whose file
+// name is also a directory is left out, and so are the entries which can not be read.
+//
+// The sizes of the files are the sizes of the bodies, and their modification times
+// are the response times. The entries are read the first time the FS is used,
+// the bodies are read when their files are read.
+func (c *Cache) FS() fs.FS {
+	return &urlFS{cache: c}
+}
+
+// urlFS is the file system returned by Cache.FS.
+type urlFS struct {
+	cache *Cache
+	once  sync.Once
+	nodes map[string]*urlNode
+	err   error
+}
+
+// urlNode is a file or a directory of an urlFS.
+type urlNode struct {
+	name     string
+	url      string // empty for a directory
+	size     int64
+	modTime  time.Time
+	children []*urlNode
+}
+
+func (n *urlNode) Name() string       { return n.name }
+func (n *urlNode) Size() int64        { return n.size }
+func (n *urlNode) ModTime() time.Time { return n.modTime }
+func (n *urlNode) IsDir() bool        { return n.url == "" }
+func (n *urlNode) Sys() interface{}   { return nil }
+
+func (n *urlNode) Mode() fs.FileMode {
+	if n.IsDir() {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// Open opens the file or the directory name.
+func (u *urlFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	u.once.Do(u.read)
+	if u.err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: u.err}
+	}
+
+	node, ok := u.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if node.IsDir() {
+		return &urlDirFile{node: node}, nil
+	}
+	return &urlFile{node: node, cache: u.cache}, nil
+}
+
+// read reads every entry of the cache, and builds the tree of their files.
+func (u *urlFS) read() {
+	u.nodes = map[string]*urlNode{".": {name: "."}}
+	files := make(map[string]*urlNode)
+
+	u.err = u.cache.Walk(func(entry *Entry, info EntryInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		name, ok := urlPath(entry.URL)
+		if !ok {
+			return nil
+		}
+		size, err := entry.bodySize()
+		if err != nil {
+			return nil
+		}
+
+		modTime := info.LastUsed
+		if t, err := entry.responseTime(); err == nil {
+			modTime = fromChromeTime(t)
+		}
+		files[name] = &urlNode{
+			name:    path.Base(name),
+			url:     entry.URL,
+			size:    size,
+			modTime: modTime,
+		}
+		return nil
+	})
+	if u.err != nil {
+		return
+	}
+
+	// the directories are created first, so that a file named like a directory is left out
+	for name := range files {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := u.nodes[dir]; ok {
+				break
+			}
+			u.nodes[dir] = &urlNode{name: path.Base(dir)}
+		}
+	}
+	for name, file := range files {
+		if _, ok := u.nodes[name]; !ok {
+			u.nodes[name] = file
+		}
+	}
+
+	for name, node := range u.nodes {
+		if name != "." {
+			parent := u.nodes[path.Dir(name)]
+			parent.children = append(parent.children, node)
+		}
+	}
+	for _, node := range u.nodes {
+		sort.Slice(node.children, func(i, j int) bool {
+			return node.children[i].name < node.children[j].name
+		})
+	}
+}
+
+// urlPath returns the name of the file of rawurl, see Cache.FS.
+func urlPath(rawurl string) (string, bool) {
+	// the keys of the double-keyed caches start with the keys of the isolation
+	if i := strings.LastIndexByte(rawurl, ' '); i >= 0 {
+		rawurl = rawurl[i+1:]
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", false
+	}
+
+	name := u.Path
+	if name == "" || strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+	if u.RawQuery != "" {
+		name += "?" + strings.Replace(u.RawQuery, "/", "%2F", -1)
+	}
+
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	name = u.Scheme + "/" + u.Host + "/" + name
+	return name, fs.ValidPath(name)
+}
+
+// bodySize returns the size of the body, see Body.
+func (e *Entry) bodySize() (int64, error) {
+	if !e.sparse {
+		return e.dataSize1, nil
+	}
+
+	body, err := e.Body()
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	var size int64
+	if sr, ok := body.(*sparseReader); ok {
+		for _, rng := range sr.ranges {
+			size += rng.Len
+		}
+	}
+	return size, nil
+}
+
+// urlDirFile is an open directory of an urlFS.
+type urlDirFile struct {
+	node   *urlNode
+	offset int
+}
+
+func (d *urlDirFile) Stat() (fs.FileInfo, error) {
+	return d.node, nil
+}
+
+func (d *urlDirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.name, Err: errors.New("is a directory")}
+}
+
+func (d *urlDirFile) Close() error {
+	return nil
+}
+
+// ReadDir reads the next n entries of the directory, or all of them if n <= 0.
+func (d *urlDirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	children := d.node.children[d.offset:]
+	if n > 0 && len(children) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(children) {
+		children = children[:n]
+	}
+	d.offset += len(children)
+
+	entries := make([]fs.DirEntry, len(children))
+	for i, child := range children {
+		entries[i] = fs.FileInfoToDirEntry(child)
+	}
+	return entries, nil
+}
+
+// urlFile is an open file of an urlFS, its body is read on the first Read or Seek.
+type urlFile struct {
+	node  *urlNode
+	cache *Cache
+	entry *Entry
+	body  io.ReadSeeker
+	err   error
+}
+
+func (f *urlFile) Stat() (fs.FileInfo, error) {
+	return f.node, nil
+}
+
+func (f *urlFile) Read(p []byte) (int, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.body.Read(p)
+}
+
+func (f *urlFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.body.Seek(offset, whence)
+}
+
+func (f *urlFile) Close() error {
+	var err error
+	if closer, ok := f.body.(io.Closer); ok {
+		err = closer.Close()
+	}
+	if f.entry != nil {
+		if cerr := f.entry.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// open opens the body of the entry.
+// A body that can not seek, like the body of a sparse entry, is read in memory.
+func (f *urlFile) open() error {
+	if f.body != nil || f.err != nil {
+		return f.err
+	}
+
+	entry, err := f.cache.Get(f.node.url)
+	if err != nil {
+		f.err = &fs.PathError{Op: "read", Path: f.node.name, Err: err}
+		return f.err
+	}
+	f.entry = entry
+
+	body, err := entry.Body()
+	if err != nil {
+		f.err = &fs.PathError{Op: "read", Path: f.node.name, Err: err}
+		return f.err
+	}
+
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		f.body = seeker
+		return nil
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		f.err = &fs.PathError{Op: "read", Path: f.node.name, Err: err}
+		return f.err
+	}
+	f.body = bytes.NewReader(data)
+	return nil
+}
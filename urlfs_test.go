@@ -0,0 +1,143 @@
+package simplecache_test
+
+import (
+	"bytes"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestCacheFS(t *testing.T) {
+	cache, err := simplecache.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := cache.FS()
+
+	err = fstest.TestFS(fsys,
+		"https/golang.org/doc/gopher/pkg.png",
+		"https/golang.org/pkg/index.html",
+		"https/golang.org/pkg/io/ioutil/index.html",
+		"https/ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var files int
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		files++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files != 19 {
+		t.Fatalf("files: %d, want: %d", files, 19)
+	}
+
+	url := "https://golang.org/doc/gopher/pkg.png"
+	body, err := fs.ReadFile(fsys, "https/golang.org/doc/gopher/pkg.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := cache.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := readBody(t, cache, url); !bytes.Equal(body, want) {
+		t.Fatalf("%s: body differs", url)
+	}
+
+	info, err := fs.Stat(fsys, "https/golang.org/doc/gopher/pkg.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(body)) {
+		t.Fatalf("size: %d, want: %d", info.Size(), len(body))
+	}
+	res, err := entry.Response()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	modTime, err := http.ParseTime(res.Header.Get("Date"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := info.ModTime().Sub(modTime); diff < -time.Minute || diff > time.Minute {
+		t.Fatalf("modtime: %v, want: %v", info.ModTime(), modTime)
+	}
+}
+
+func TestCacheFSServer(t *testing.T) {
+	cache, err := simplecache.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.FileServer(http.FS(cache.FS())))
+	defer server.Close()
+
+	for path, contentType := range map[string]string{
+		"/https/golang.org/doc/gopher/pkg.png":  "image/png",
+		"/https/golang.org/pkg/io/":             "text/html; charset=utf-8",
+		"/https/golang.org/lib/godoc/style.css": "text/css; charset=utf-8",
+	} {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status: %d, want: %d", path, res.StatusCode, http.StatusOK)
+		}
+		if actual := res.Header.Get("Content-Type"); actual != contentType {
+			t.Fatalf("%s: content-type: %s, want: %s", path, actual, contentType)
+		}
+		if len(body) == 0 {
+			t.Fatalf("%s: body is empty", path)
+		}
+	}
+}
+
+func TestCacheFSSparse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Cache")
+	cache, err := simplecache.CreateCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "https://example.com/video.mp4?range=1"
+	if err = cache.WriteSparse(url, 0, []byte("sparse")); err != nil {
+		t.Fatal(err)
+	}
+	if err = cache.WriteSparse(url, 10, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := cache.FS()
+	name := "https/example.com/video.mp4?range=1"
+	if err = fstest.TestFS(fsys, name); err != nil {
+		t.Fatal(err)
+	}
+	body, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "sparsedata" {
+		t.Fatalf("body: %q, want: %q", body, "sparsedata")
+	}
+}
@@ -0,0 +1,228 @@
+package simplecache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"sort"
+)
+
+// VerifyResult is the result of the verification of an index file or of an entry.
+type VerifyResult struct {
+	// Name is "index", "index-dir/the-real-index", or the hash of an entry like "329f9c2d34eb0523".
+	Name string
+	// URL is the URL of the entry, it is empty if the entry file can not be read.
+	URL string
+	// Problems describes each problem found, it is empty if the file or the entry is valid.
+	Problems []string
+}
+
+// Verify checks the integrity of the cache, and returns a result for each index file and for each entry.
+//
+// The fake index "path/index" and the real index "path/index-dir/the-real-index"
+// are verified, including the CRC of the real index. The entries are listed from the
+// real index and from the entry files: the header, the key hash, the stream CRCs and
+// the SHA-256 of the key of the files named "path/hash_0" and "path/hash_1" are
+// verified, and so are the range CRCs of the files named "path/hash_s".
+// The entries missing from the real index, the index entries without files, and
+// the files "path/hash_1" or "path/hash_s" without a file "path/hash_0" are reported.
+//
+// The entries are verified by c.Workers goroutines, and returned sorted by hash.
+// An error is returned if the cache directory can not be read,
+// or if ctx is done before every entry is verified.
+func (c *Cache) Verify(ctx context.Context) ([]VerifyResult, error) {
+	results := []VerifyResult{{Name: "index"}, {Name: "index-dir/the-real-index"}}
+
+	if err := checkFakeIndex(c.fsys); err != nil {
+		results[0].Problems = append(results[0].Problems, err.Error())
+	}
+
+	inIndex := make(map[uint64]bool)
+	index, err := readIndex(c.fsys)
+	if err != nil {
+		results[1].Problems = append(results[1].Problems, err.Error())
+	} else {
+		results[1].Problems = verifyIndex(c.fsys, index)
+		for _, entry := range index.Entries {
+			inIndex[entry.Hash] = true
+		}
+	}
+
+	files, err := scanEntryFiles(c.fsys)
+	if err != nil {
+		return results, fmt.Errorf("verify %s: %v", c.path, err)
+	}
+	byHash := make(map[uint64]*entryFiles, len(files))
+	for _, f := range files {
+		byHash[f.hash] = f
+	}
+
+	hashes := make([]uint64, 0, len(byHash))
+	for hash := range byHash {
+		hashes = append(hashes, hash)
+	}
+	for hash := range inIndex {
+		if byHash[hash] == nil {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	entries := make([]VerifyResult, len(hashes))
+	err = forEach(ctx, len(hashes), c.workers(), func(i int) {
+		hash := hashes[i]
+		entries[i] = c.verifyEntry(hash, byHash[hash])
+		if !inIndex[hash] {
+			entries[i].Problems = append(entries[i].Problems, "entry not in the real index")
+		}
+	})
+	if err != nil {
+		return results, fmt.Errorf("verify %s: %v", c.path, err)
+	}
+	return append(results, entries...), nil
+}
+
+// verifyIndex checks the payload size, the CRC and the cache size of the real index.
+func verifyIndex(fsys fs.FS, index *realIndex) []string {
+	var problems []string
+
+	data, err := fs.ReadFile(fsys, "index-dir/the-real-index")
+	if err != nil {
+		return append(problems, err.Error())
+	}
+
+	payload := binary.LittleEndian.Uint32(data[0:])
+	if int64(payload) != int64(len(data))-8 {
+		problems = append(problems, fmt.Sprintf("payload size: %d, want: %d", payload, len(data)-8))
+	} else if actual := crc32.ChecksumIEEE(data[8:]); index.Header.CRC != actual {
+		problems = append(problems, fmt.Sprintf("CRC: %x, want: %x", index.Header.CRC, actual))
+	}
+
+	var size uint64
+	for _, entry := range index.Entries {
+		size += index.entrySize(entry)
+	}
+	if index.Header.CacheSize != size {
+		problems = append(problems, fmt.Sprintf("cache size: %d, want: %d", index.Header.CacheSize, size))
+	}
+	return problems
+}
+
+// verifyEntry checks the files of the entry of hash.
+func (c *Cache) verifyEntry(hash uint64, files *entryFiles) VerifyResult {
+	result := VerifyResult{Name: fmt.Sprintf("%016x", hash)}
+	problemf := func(format string, args ...interface{}) {
+		result.Problems = append(result.Problems, fmt.Sprintf(format, args...))
+	}
+
+	if files == nil {
+		problemf("index entry without files")
+		return result
+	}
+	suffixes := make(map[string]bool)
+	for _, name := range files.names {
+		_, suffix, _ := parseEntryName(name)
+		suffixes[suffix] = true
+	}
+
+	if !suffixes["0"] {
+		for _, suffix := range []string{"1", "s"} {
+			if suffixes[suffix] {
+				problemf("orphan file %s_%s", result.Name, suffix)
+			}
+		}
+		return result
+	}
+
+	entry, err := c.getHash(hash)
+	if err != nil {
+		problemf("%s_0: %v", result.Name, err)
+		if url, err := c.readURL(hash); err == nil {
+			result.URL = url
+		}
+		return result
+	}
+	defer entry.Close()
+	result.URL = entry.URL
+	if actual := entryHash(entry.URL); actual != hash {
+		problemf("%s_0: key hash: %016x, want: %016x", result.Name, actual, hash)
+	}
+
+	if suffixes["1"] {
+		if err = verifyStream2(c.fsys, result.Name+"_1", entry.URL, c.limits()); err != nil {
+			problemf("%s_1: %v", result.Name, err)
+		}
+	}
+	if suffixes["s"] {
+		if err = verifySparse(c.fsys, result.Name+"_s", entry.URL, c.limits()); err != nil {
+			problemf("%s_s: %v", result.Name, err)
+		}
+	}
+	return result
+}
+
+// verifyStream2 checks the file "hash_1", which holds the stream 2 of an entry.
+func verifyStream2(fsys fs.FS, name, url string, limits Limits) error {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+
+	entry := Entry{limits: limits}
+	if err = checkKeyLen(data); err != nil {
+		return err
+	}
+	if err = entry.readHeader(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	if entry.URL != url {
+		return fmt.Errorf("key: %s, want: %s", entry.URL, url)
+	}
+
+	s := salvage{data: data}
+	eof, ok := s.readEOF(int64(len(data)) - entryEOFSize)
+	if !ok {
+		return fmt.Errorf("stream2 magic: %x, want: %x", eof.Magic, finalMagicNumber)
+	}
+
+	start := entryHeaderSize + entry.keyLen
+	size := int64(len(data)) - entryEOFSize - start
+	if int64(eof.StreamSize) != size {
+		return fmt.Errorf("stream2 size: %d, want: %d", eof.StreamSize, size)
+	}
+	if actual := crc32.ChecksumIEEE(data[start : start+size]); eof.HasCRC32() && eof.CRC != actual {
+		return fmt.Errorf("stream2 CRC: %x, want: %x", eof.CRC, actual)
+	}
+	return nil
+}
+
+// verifySparse checks the header and the range CRCs of a sparse file.
+func verifySparse(fsys fs.FS, name, url string, limits Limits) error {
+	file, err := openFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	defer close(file)
+
+	key, err := readSparseHeader(file, limits)
+	if err != nil {
+		return err
+	}
+	if key != url {
+		return fmt.Errorf("key: %s, want: %s", key, url)
+	}
+
+	ranges, err := scan(context.Background(), file, entryHeaderSize+int64(len(key)), limits)
+	if err != nil {
+		return err
+	}
+	for _, rng := range ranges {
+		if err = checkRangeCRC(file, rng); err != nil {
+			return err
+		}
+	}
+	return nil
+}
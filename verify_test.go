@@ -0,0 +1,92 @@
+package simplecache_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestVerify(t *testing.T) {
+	path := copyCache(t, "testdata")
+	defer os.RemoveAll(path)
+
+	cache, err := simplecache.OpenCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := cache.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2+19 {
+		t.Fatalf("results: %d, want: %d", len(results), 2+19)
+	}
+	testProblems(t, results, map[string]int{})
+
+	// corrupt body
+	corruptEntry(t, path, "https://golang.org/doc/gopher/pkg.png", func(data []byte) []byte {
+		data[len(data)/2] ^= 0xff
+		return data
+	})
+	// index entry without files
+	if err = os.Remove(filepath.Join(path, "329f9c2d34eb0523_0")); err != nil {
+		t.Fatal(err)
+	}
+	// orphan file, whose entry is not in the index
+	err = ioutil.WriteFile(filepath.Join(path, "0123456789abcdef_s"), []byte("sparse"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// entry file named after another key, not in the index
+	data, err := ioutil.ReadFile(filepath.Join(path, "36d2a4716c77194d_0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(path, "fedcba9876543210_0"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// real index CRC
+	name := filepath.Join(path, "index-dir", "the-real-index")
+	if data, err = ioutil.ReadFile(name); err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err = ioutil.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err = cache.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2+19+2 {
+		t.Fatalf("results: %d, want: %d", len(results), 2+19+2)
+	}
+	testProblems(t, results, map[string]int{
+		"index-dir/the-real-index": 1,
+		"329f9c2d34eb0523":         1,
+		"bb9d1cda868d278c":         1,
+		"0123456789abcdef":         2,
+		"fedcba9876543210":         2,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = cache.Verify(ctx); err == nil {
+		t.Fatal("err is nil")
+	}
+}
+
+func testProblems(t *testing.T, results []simplecache.VerifyResult, want map[string]int) {
+	for _, result := range results {
+		if len(result.Problems) != want[result.Name] {
+			t.Fatalf("%s: problems: %q, want: %d", result.Name, result.Problems, want[result.Name])
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package simplecache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SkipAll is returned by a WalkFunc to stop Walk, which then returns nil.
+var SkipAll = errors.New("skip all entries")
+
+// EntryInfo is the metadata of an entry, as stored in the-real-index file.
+type EntryInfo struct {
+	Hash     uint64
+	Size     uint64
+	LastUsed time.Time
+}
+
+// WalkFunc is called by Walk for each entry of the cache.
+//
+// entry is nil and err is not nil if the entry file can not be read,
+// the walk goes on unless WalkFunc returns an error.
+type WalkFunc func(entry *Entry, info EntryInfo, err error) error
+
+// Walk calls fn for each entry of the cache, in the order of the-real-index file.
+//
+// Each entry file named "path/hash_0" is read and verified once, like with Get,
+// and the Entry is passed to fn along with its index metadata. The files held open
+// by the Entry are closed when fn returns, see Entry.Close.
+// Walk stops if fn returns an error, and returns it unless it is SkipAll.
+// An error is returned if the format of the index files is unexpected.
+func (c *Cache) Walk(fn WalkFunc) error {
+	index, err := readIndex(c.fsys)
+	if err != nil {
+		return fmt.Errorf("walk %s: %v", c.path, err)
+	}
+
+	for _, ie := range index.Entries {
+		info := EntryInfo{
+			Hash:     ie.Hash,
+			Size:     index.entrySize(ie),
+			LastUsed: fromChromeTime(ie.LastUsed),
+		}
+
+		entry, err := c.getHash(ie.Hash)
+		if err != nil {
+			err = fmt.Errorf("getting %016x_0: %v", ie.Hash, err)
+		}
+
+		err = fn(entry, info, err)
+		if entry != nil {
+			entry.Close()
+		}
+		if err == SkipAll {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package simplecache_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestWalk(t *testing.T) {
+	cache, err := simplecache.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls, err := cache.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var walked []string
+	err = cache.Walk(func(entry *simplecache.Entry, info simplecache.EntryInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Size == 0 || info.LastUsed.IsZero() {
+			t.Fatalf("%s: info: %+v", entry.URL, info)
+		}
+		walked = append(walked, entry.URL)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(walked) != len(urls) {
+		t.Fatalf("walked: %d, want: %d", len(walked), len(urls))
+	}
+	for i := range urls {
+		if walked[i] != urls[i] {
+			t.Fatalf("walked: %s, want: %s", walked[i], urls[i])
+		}
+	}
+
+	// early termination
+	var count int
+	err = cache.Walk(func(entry *simplecache.Entry, info simplecache.EntryInfo, err error) error {
+		count++
+		return simplecache.SkipAll
+	})
+	if err != nil || count != 1 {
+		t.Fatalf("walk: %v, count: %d", err, count)
+	}
+
+	stop := errors.New("stop")
+	err = cache.Walk(func(entry *simplecache.Entry, info simplecache.EntryInfo, err error) error {
+		return stop
+	})
+	if err != stop {
+		t.Fatalf("walk: %v, want: %v", err, stop)
+	}
+}
+
+func TestWalkError(t *testing.T) {
+	path := copyCache(t, "testdata")
+	defer os.RemoveAll(path)
+
+	if err := os.Remove(filepath.Join(path, "329f9c2d34eb0523_0")); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := simplecache.OpenCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries, errs int
+	err = cache.Walk(func(entry *simplecache.Entry, info simplecache.EntryInfo, err error) error {
+		if err != nil {
+			if entry != nil || info.Hash != 0x329f9c2d34eb0523 {
+				t.Fatalf("entry: %v, info: %+v", entry, info)
+			}
+			errs++
+		} else {
+			entries++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries != 18 || errs != 1 {
+		t.Fatalf("entries: %d, errors: %d, want: 18, 1", entries, errs)
+	}
+}
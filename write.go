@@ -0,0 +1,404 @@
+package simplecache
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// responseInfoVersion is the version of the pickled HTTP response info (stream 0).
+const responseInfoVersion int32 = 3
+
+// CreateCache creates an empty simple cache directory at path.
+// The directory is created if it does not exist,
+// an error is returned if it already contains a cache.
+func CreateCache(path string) (*Cache, error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, fmt.Errorf("create %s: %v", path, err)
+	}
+	if _, err := os.Stat(filepath.Join(path, "index")); err == nil {
+		return nil, fmt.Errorf("create %s: index file exists", path)
+	}
+
+	index := realIndex{
+		Header:   indexHeader{Version: indexVersion},
+		Modified: chromeTime(time.Now()),
+	}
+	if err := writeIndex(path, &index); err != nil {
+		return nil, fmt.Errorf("create %s: %v", path, err)
+	}
+	if err := writeFakeIndex(path, indexVersion); err != nil {
+		return nil, fmt.Errorf("create %s: %v", path, err)
+	}
+	return &Cache{path: path, fsys: os.DirFS(path)}, nil
+}
+
+// Put stores the response for key in the cache, replacing any previous entry.
+// The response body is read until EOF and closed.
+//
+// Put removes the files named "path/hash(key)_1" and "path/hash(key)_s", replaces the file
+// named "path/hash(key)_0", and updates the file named "path/index-dir/the-real-index".
+// The file "path/hash(key)_0" is written to a temporary file first, so that the previous
+// entry is kept if it can not be written.
+func (c *Cache) Put(key string, resp *http.Response) error {
+	var body []byte
+	if resp.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(resp.Body)
+		if cerr := resp.Body.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("put %s: %v", key, err)
+		}
+	}
+
+	now := time.Now()
+	stream0 := encodeResponseInfo(resp, now, now)
+	if err := c.putStreams(key, stream0, body, true, now); err != nil {
+		return fmt.Errorf("put %s: %v", key, err)
+	}
+	return nil
+}
+
+// PutRecovered stores the streams of a recovered entry in the cache,
+// replacing any previous entry, see Put.
+//
+// The streams of a truncated entry are written without CRC, so that the entry is not
+// taken for intact. An error is returned if the HTTP response info (stream 0) was not
+// recovered.
+func (c *Cache) PutRecovered(e *RecoveredEntry) error {
+	if e.stream0 == nil {
+		return fmt.Errorf("put %s: stream0 not recovered", e.URL)
+	}
+	if err := c.putStreams(e.URL, e.stream0, e.stream1, !e.Truncated, time.Now()); err != nil {
+		return fmt.Errorf("put %s: %v", e.URL, err)
+	}
+	return nil
+}
+
+// putStreams writes the entry file of key with stream0 and stream1, and their CRCs
+// if crc is true. The entry is last used at now.
+func (c *Cache) putStreams(key string, stream0, stream1 []byte, crc bool, now time.Time) error {
+	if err := c.checkWritable(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index, err := readIndex(c.fsys)
+	if err != nil {
+		return err
+	}
+
+	data := encodeEntry(key, stream0, stream1, index.Header.Version >= keySHA256Version, crc)
+	hash := entryHash(key)
+
+	// the file "hash_0" is replaced by writeFile, it is kept if the write fails
+	if _, err = c.removeFiles(hash, "_1", "_s"); err != nil {
+		return err
+	}
+	name := filepath.Join(c.path, fmt.Sprintf("%016x_0", hash))
+	if err = writeFile(name, data); err != nil {
+		return err
+	}
+
+	index.put(indexEntry{
+		Hash:     hash,
+		LastUsed: chromeTime(now),
+		Size:     index.packSize(uint64(len(data)), 0),
+	})
+	index.Modified = chromeTime(now)
+
+	return writeIndex(c.path, index)
+}
+
+// WriteSparse writes data at offset of the sparse stream of the entry for key,
+// like the media cache of Chromium stores range requests.
+//
+// WriteSparse appends ranges to the file named "path/hash(key)_s", the parts of data
+// overlapping existing ranges are written in place. The file named "path/hash(key)_0"
+// is written with a zero-length stream 1, keeping the HTTP response info (stream 0)
+// of an existing entry or a "200 OK" response without header otherwise.
+// The file named "path/index-dir/the-real-index" is updated.
+func (c *Cache) WriteSparse(key string, offset int64, data []byte) error {
+	if offset < 0 {
+		return fmt.Errorf("write sparse %s: negative offset: %d", key, offset)
+	}
+	if err := c.checkWritable(); err != nil {
+		return fmt.Errorf("write sparse %s: %v", key, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index, err := readIndex(c.fsys)
+	if err != nil {
+		return fmt.Errorf("write sparse %s: %v", key, err)
+	}
+
+	now := time.Now()
+	hash := entryHash(key)
+
+	var stream0 []byte
+	entry, err := c.getHash(hash)
+	if os.IsNotExist(err) {
+		resp := http.Response{StatusCode: http.StatusOK, ProtoMajor: 1, ProtoMinor: 1}
+		stream0 = encodeResponseInfo(&resp, now, now)
+	} else if err != nil {
+		return fmt.Errorf("write sparse %s: %v", key, err)
+	} else {
+		if entry.URL != key {
+			err = fmt.Errorf("key mismatch: %s", entry.URL)
+		} else if entry.dataSize1 > 0 {
+			stream0, err = entry.readStream(entry.name0, entry.offset0, entry.dataSize0)
+		}
+		// the entry files are rewritten, they are not held open meanwhile
+		entry.Close()
+		if err != nil {
+			return fmt.Errorf("write sparse %s: %v", key, err)
+		}
+	}
+
+	if stream0 != nil {
+		keySHA256 := index.Header.Version >= keySHA256Version
+		name := filepath.Join(c.path, fmt.Sprintf("%016x_0", hash))
+		if err = writeFile(name, encodeEntry(key, stream0, nil, keySHA256, true)); err != nil {
+			return fmt.Errorf("write sparse %s: %v", key, err)
+		}
+	}
+
+	name := filepath.Join(c.path, fmt.Sprintf("%016x_s", hash))
+	if err = writeSparseRanges(name, key, index.Header.Version, offset, data, c.limits()); err != nil {
+		return fmt.Errorf("write sparse %s: %v", key, err)
+	}
+
+	index.put(indexEntry{
+		Hash:     hash,
+		LastUsed: chromeTime(now),
+		Size:     index.packSize(uint64(c.filesSize(hash)), 0),
+	})
+	index.Modified = chromeTime(now)
+
+	if err = writeIndex(c.path, index); err != nil {
+		return fmt.Errorf("write sparse %s: %v", key, err)
+	}
+	return nil
+}
+
+// Remove removes the entry for key from the cache.
+//
+// Remove deletes the files named "path/hash(key)_0", "path/hash(key)_1" and
+// "path/hash(key)_s", and updates the file named "path/index-dir/the-real-index".
+// An error is returned if the cache has no such entry.
+func (c *Cache) Remove(key string) error {
+	if err := c.checkWritable(); err != nil {
+		return fmt.Errorf("remove %s: %v", key, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index, err := readIndex(c.fsys)
+	if err != nil {
+		return fmt.Errorf("remove %s: %v", key, err)
+	}
+
+	hash := entryHash(key)
+	found, err := c.removeFiles(hash, "_0", "_1", "_s")
+	if err != nil {
+		return fmt.Errorf("remove %s: %v", key, err)
+	}
+	if !index.remove(hash) && !found {
+		return fmt.Errorf("remove %s: entry not found", key)
+	}
+
+	index.Modified = chromeTime(time.Now())
+	if err = writeIndex(c.path, index); err != nil {
+		return fmt.Errorf("remove %s: %v", key, err)
+	}
+	return nil
+}
+
+// removeFiles deletes the entry files of hash with the given suffixes,
+// it returns true if any file was deleted.
+func (c *Cache) removeFiles(hash uint64, suffixes ...string) (bool, error) {
+	var found bool
+	for _, suffix := range suffixes {
+		name := filepath.Join(c.path, fmt.Sprintf("%016x%s", hash, suffix))
+		err := os.Remove(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return found, err
+		}
+		found = true
+	}
+	return found, nil
+}
+
+// filesSize returns the total size of the entry files of hash.
+func (c *Cache) filesSize(hash uint64) int64 {
+	var size int64
+	for _, suffix := range []string{"_0", "_1", "_s"} {
+		info, err := fs.Stat(c.fsys, fmt.Sprintf("%016x%s", hash, suffix))
+		if err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// entryHash returns the hash of key, used to name the entry files.
+func entryHash(key string) uint64 {
+	sum := sha1.Sum([]byte(key))
+	return binary.LittleEndian.Uint64(sum[:8])
+}
+
+// encodeEntry returns the content of an entry file "hash(key)_0".
+// The SHA-256 of key follows stream 0 if keySHA256 is true,
+// the EOF records hold the CRCs of the streams if crc is true.
+func encodeEntry(key string, stream0, stream1 []byte, keySHA256, crc bool) []byte {
+	var buf bytes.Buffer
+
+	binary.Write(&buf, binary.LittleEndian, entryHeader{
+		Magic:   initialMagicNumber,
+		Version: entryVersion,
+		KeyLen:  int32(len(key)),
+		KeyHash: superFastHash([]byte(key)),
+	})
+	buf.WriteString(key)
+
+	eof1 := entryEOF{Magic: finalMagicNumber, StreamSize: int32(len(stream1))}
+	eof0 := entryEOF{Magic: finalMagicNumber, StreamSize: int32(len(stream0))}
+	if crc {
+		eof1.Flag, eof1.CRC = flagCRC32, crc32.ChecksumIEEE(stream1)
+		eof0.Flag, eof0.CRC = flagCRC32, crc32.ChecksumIEEE(stream0)
+	}
+
+	buf.Write(stream1)
+	binary.Write(&buf, binary.LittleEndian, eof1)
+
+	buf.Write(stream0)
+	if keySHA256 {
+		sum256 := sha256.Sum256([]byte(key))
+		buf.Write(sum256[:])
+		eof0.Flag |= flagSHA256
+	}
+	binary.Write(&buf, binary.LittleEndian, eof0)
+
+	return buf.Bytes()
+}
+
+// encodeResponseInfo returns the pickled HTTP response info (stream 0).
+//
+// The headers are stored as the status line followed by the header lines,
+// each line being terminated by a NUL byte, and a final NUL byte.
+// The remote endpoint is left empty.
+func encodeResponseInfo(resp *http.Response, request, response time.Time) []byte {
+	var headers bytes.Buffer
+	fmt.Fprintf(&headers, "HTTP/%d.%d %s\x00", resp.ProtoMajor, resp.ProtoMinor, statusText(resp))
+
+	names := make([]string, 0, len(resp.Header))
+	for name := range resp.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range resp.Header[name] {
+			fmt.Fprintf(&headers, "%s: %s\x00", name, value)
+		}
+	}
+	headers.WriteByte(0)
+
+	var p pickle
+	p.writeInt32(responseInfoVersion)
+	p.writeInt64(chromeTime(request))
+	p.writeInt64(chromeTime(response))
+	p.writeString(headers.String())
+	p.writeString("") // remote endpoint host
+	p.writeInt32(0)   // remote endpoint port
+	return p.encode()
+}
+
+// statusText returns the status of resp without its protocol, like "200 OK".
+func statusText(resp *http.Response) string {
+	if resp.Status != "" {
+		return resp.Status
+	}
+	text := http.StatusText(resp.StatusCode)
+	if text == "" {
+		return fmt.Sprintf("%d", resp.StatusCode)
+	}
+	return fmt.Sprintf("%d %s", resp.StatusCode, text)
+}
+
+// pickle writes a Chromium base::Pickle:
+// a payload size followed by the payload, every value aligned on 4 bytes.
+type pickle struct {
+	payload bytes.Buffer
+}
+
+func (p *pickle) writeInt32(v int32) {
+	binary.Write(&p.payload, binary.LittleEndian, v)
+}
+
+func (p *pickle) writeInt64(v int64) {
+	binary.Write(&p.payload, binary.LittleEndian, v)
+}
+
+func (p *pickle) writeString(s string) {
+	p.writeInt32(int32(len(s)))
+	p.payload.WriteString(s)
+	for p.payload.Len()%4 != 0 {
+		p.payload.WriteByte(0)
+	}
+}
+
+func (p *pickle) encode() []byte {
+	buf := make([]byte, 4, 4+p.payload.Len())
+	binary.LittleEndian.PutUint32(buf, uint32(p.payload.Len()))
+	return append(buf, p.payload.Bytes()...)
+}
+
+// writeFile writes data to a temporary file renamed to name once written.
+func writeFile(name string, data []byte) error {
+	temp, err := writeTemp(filepath.Dir(name), data)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(temp, name); err != nil {
+		os.Remove(temp)
+	}
+	return err
+}
+
+// writeTemp writes data to a new temporary file of the directory dir, and returns its name.
+func writeTemp(dir string, data []byte) (string, error) {
+	file, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = file.Write(data)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
@@ -0,0 +1,51 @@
+//go:build linux
+
+package simplecache_test
+
+import (
+	"bytes"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestPutFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Cache")
+	cache, err := simplecache.CreateCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "https://example.com/"
+	want := []byte("old body")
+	if err = cache.Put(url, newResponse(want)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the files larger than the limit can not be written
+	var limit syscall.Rlimit
+	if err = syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	signal.Ignore(syscall.SIGXFSZ)
+	defer signal.Reset(syscall.SIGXFSZ)
+	small := limit
+	small.Cur = 4096
+	if err = syscall.Setrlimit(syscall.RLIMIT_FSIZE, &small); err != nil {
+		t.Skip(err)
+	}
+	err = cache.Put(url, newResponse(bytes.Repeat([]byte("new body"), 1024)))
+	if rerr := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit); rerr != nil {
+		t.Fatal(rerr)
+	}
+	if err == nil {
+		t.Fatal("err is nil")
+	}
+
+	// the previous entry is kept
+	if body := readBody(t, cache, url); !bytes.Equal(body, want) {
+		t.Fatalf("body: %q, want: %q", body, want)
+	}
+}
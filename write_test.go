@@ -0,0 +1,153 @@
+package simplecache_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/schorlet/simplecache"
+)
+
+func TestPut(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "Cache")
+	cache, err := simplecache.CreateCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = simplecache.CreateCache(path); err == nil {
+		t.Fatal("err is nil")
+	}
+
+	src, err := simplecache.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls, err := src.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range urls {
+		copyEntry(t, src, cache, url)
+	}
+	// replace an entry
+	copyEntry(t, src, cache, urls[0])
+
+	putURLs, err := simplecache.URLs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(putURLs) != len(urls) {
+		t.Fatalf("urls: %d, want: %d", len(putURLs), len(urls))
+	}
+
+	for _, url := range urls {
+		testEntry(t, url, path)
+		testSameBody(t, src, cache, url)
+	}
+
+	if err = cache.Put("http://foo.com/", &http.Response{
+		StatusCode: http.StatusNoContent,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Length": {"0"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	testEntry(t, "http://foo.com/", path)
+}
+
+func copyEntry(t *testing.T, src, dst *simplecache.Cache, url string) {
+	entry, err := src.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := entry.Response()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dst.Put(url, resp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testSameBody(t *testing.T, src, dst *simplecache.Cache, url string) {
+	bodies := make([][]byte, 2)
+	for i, cache := range []*simplecache.Cache{src, dst} {
+		entry, err := cache.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := entry.Body()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies[i], err = ioutil.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body.Close()
+	}
+	if !bytes.Equal(bodies[0], bodies[1]) {
+		t.Fatalf("%s: body differs", url)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	path := copyCache(t, "testdata")
+	defer os.RemoveAll(path)
+
+	cache, err := simplecache.OpenCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls, err := cache.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := "https://golang.org/doc/gopher/pkg.png"
+	if err = cache.Remove(url); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cache.Get(url); err == nil {
+		t.Fatal("err is nil")
+	}
+	if err = cache.Remove(url); err == nil {
+		t.Fatal("err is nil")
+	}
+
+	name := filepath.Join(path, "index-dir", "the-real-index")
+	testRealIndex(t, name, 6, len(urls)-1)
+
+	removed, err := cache.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != len(urls)-1 {
+		t.Fatalf("urls: %d, want: %d", len(removed), len(urls)-1)
+	}
+	for _, u := range removed {
+		if u == url {
+			t.Fatalf("url %s not removed", url)
+		}
+	}
+}
+
+// newResponse returns a response of status 200 OK with body.
+func newResponse(body []byte) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+	}
+}